@@ -1,17 +1,32 @@
 package cab
 
 import (
+	"context"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Cabinet is an opened cabinet or multi-cabinet set.
+//
+// A Cabinet and its Files are safe for concurrent use: files may be opened and read in several goroutines at once,
+// as long as the underlying io.ReaderAt supports concurrent reads. Each reader returned by File.Open or
+// File.OpenReaderAt must only be used by one goroutine at a time.
 type Cabinet struct {
 	Files               []*File
 	ReservedHeaderBlock []byte
+	Reserve             Reserve // Sizes of the reserved areas
 	MultiCabinetInfo
+
+	folders   []*cabinetFileFolder
+	closers   []io.Closer
+	checksums *checksumReport
+
+	fsTree     *fsTree
+	fsTreeOnce sync.Once
 }
 
 type MultiCabinetInfo struct {
@@ -23,92 +38,60 @@ type MultiCabinetInfo struct {
 	SetIndex     uint16 // Index of this cabinet in the multi-cabinet set
 }
 
-// Helper flag for fuzzing:
-// cabextract assumes that CFFILE structs are immediately after CFFOLDER, which is usually the case, but not necessary according to the specification.
-// With this flag set, we return an error if this assumption is incorrect.
-var requireDirectCfFileFollow = false
-
+// Open opens a cabinet with the default options.
 func Open(reader io.ReaderAt, size int64) (*Cabinet, error) {
-	fullReader := io.NewSectionReader(reader, 0, size)
-	var cab Cabinet
-
-	var cfHeader cabinetFileHeader
-	if err := binary.Read(fullReader, binary.LittleEndian, &cfHeader); err != nil {
-		return nil, err
-	}
+	return OpenWithOptions(reader, size, nil)
+}
 
-	if cfHeader.Signature != [4]byte{0x4D, 0x53, 0x43, 0x46} {
-		return nil, errors.New("CAB signature did not match")
-	}
+// OpenWithOptions opens a cabinet with the given options, which may be nil.
+func OpenWithOptions(reader io.ReaderAt, size int64, opts *Options) (*Cabinet, error) {
+	return OpenContext(context.Background(), reader, size, opts)
+}
 
-	if cfHeader.VersionMajor != 1 {
-		return nil, errors.New("unsupported major version")
-	}
-	if cfHeader.VersionMinor > 3 {
-		return nil, errors.New("unsupported minor version")
-	}
-	cab.SetIndex = cfHeader.SetIndex
-	cab.SetId = cfHeader.SetId
+// OpenContext opens a cabinet with the given options, which may be nil. Once ctx is done, parsing stops and
+// ctx.Err() is returned; this is checked before every structure that is read from reader. ctx only applies to
+// opening the cabinet, see File.OpenContext for reading files.
+func OpenContext(ctx context.Context, reader io.ReaderAt, size int64, opts *Options) (*Cabinet, error) {
+	options := opts.normalized()
+	fullReader := io.NewSectionReader(reader, 0, size)
+	structures := sectionStructureReader{fullReader}
+	cab := Cabinet{checksums: options.report}
 
-	cabinetReserve := cfHeader.Flags&cabinetReserveExists != 0
-	var reservedSizes cabinetFileReservedSizes
-	if cabinetReserve {
-		if err := binary.Read(fullReader, binary.LittleEndian, &reservedSizes); err != nil {
-			return nil, err
-		}
-	}
-	var reservedHeaderBlock = make([]byte, reservedSizes.ReservedHeaderSize)
-	if _, err := io.ReadFull(fullReader, reservedHeaderBlock); err != nil {
+	cfHeader, reservedSizes, err := cab.readHeader(structures, &options)
+	if err != nil {
 		return nil, err
 	}
-	cab.ReservedHeaderBlock = reservedHeaderBlock
-
-	previousCabinet := cfHeader.Flags&previousCabinetExists != 0
-	if previousCabinet {
-		var err error
-		if cab.PreviousFile, err = readZeroTerminatedString(fullReader); err != nil {
-			return nil, err
-		}
-		if cab.PreviousDisk, err = readZeroTerminatedString(fullReader); err != nil {
-			return nil, err
-		}
-	}
 
-	nextCabinet := cfHeader.Flags&nextCabinetExists != 0
-	if nextCabinet {
-		var err error
-		if cab.NextFile, err = readZeroTerminatedString(fullReader); err != nil {
-			return nil, err
-		}
-		if cab.NextDisk, err = readZeroTerminatedString(fullReader); err != nil {
-			return nil, err
-		}
-	}
-
-	folders, err := readFolderEntries(fullReader, cfHeader.FolderCount, reservedSizes.ReservedFolderSize)
+	folders, err := readFolderEntries(ctx, structures, cfHeader.FolderCount, reservedSizes.ReservedFolderSize)
 	if err != nil {
 		return nil, err
 	}
 
 	postFolderOffset, _ := fullReader.Seek(0, io.SeekCurrent)
+	if err := options.checkDirectory(cfHeader, folders, postFolderOffset); err != nil {
+		return nil, err
+	}
 
 	// Look up data entries for each folder
 	for i := range folders {
 		folder := &folders[i]
+		dataEntrySize := int64(binary.Size(cabinetFileDataHeader{})) + int64(reservedSizes.ReservedDatablockSize)
+		if int64(folder.CoffCabStart)+int64(folder.CfDataCount)*dataEntrySize > size {
+			// Don't allocate data entries that can't exist
+			return nil, dataError(i, -1, int64(folder.CoffCabStart), ErrTruncated)
+		}
 		if _, err := fullReader.Seek(int64(folder.CoffCabStart), io.SeekStart); err != nil {
 			return nil, err
 		}
-		dataEntries, err := readDataEntries(fullReader, folder.CfDataCount, reservedSizes.ReservedDatablockSize)
+		dataEntries, err := readDataEntries(ctx, fullReader, i, folder.CfDataCount, reservedSizes.ReservedDatablockSize)
 		if err != nil {
 			return nil, err
 		}
 		folder.dataEntries = dataEntries
+		folder.options = &options
 	}
-
-	if requireDirectCfFileFollow {
-		if int64(cfHeader.FirstFileEntryOffset) != postFolderOffset {
-			return nil, errors.New("offset between CFFOLDER and CFFILE")
-		}
+	if err := options.checkFolderData(folders); err != nil {
+		return nil, err
 	}
 
 	_, err = fullReader.Seek(int64(cfHeader.FirstFileEntryOffset), io.SeekStart)
@@ -116,28 +99,131 @@ func Open(reader io.ReaderAt, size int64) (*Cabinet, error) {
 		return nil, err
 	}
 
-	fileEntries, err := readFileEntries(fullReader, cfHeader.FileCount)
+	fileEntries, err := readFileEntries(ctx, structures, cfHeader.FileCount, options.Limits.MaxNameLength)
 	if err != nil {
 		return nil, err
 	}
+	if err := options.checkFiles(fileEntries); err != nil {
+		return nil, err
+	}
+
+	for i := range folders {
+		cab.folders = append(cab.folders, &folders[i])
+	}
 
 	for _, fileEntry := range fileEntries {
-		if int(fileEntry.FolderIndex) >= len(folders) {
-			return nil, errors.New("invalid folder reference")
+		folder, err := cab.resolveFolder(fileEntry)
+		if err != nil {
+			return nil, err
+		}
+		file, err := options.newFile(fileEntry, folder)
+		if err != nil {
+			return nil, err
 		}
-		folder := &folders[fileEntry.FolderIndex]
-		cab.Files = append(cab.Files, &File{
-			Name:       fileEntry.fileName,
-			Modified:   parseCabTimestamp(fileEntry.Date, fileEntry.Time),
-			Attributes: fileEntry.Attributes,
-			folder:     folder,
-			header:     fileEntry.cabinetFileEntryHeader,
-		})
+		cab.Files = append(cab.Files, file)
 	}
 
 	return &cab, nil
 }
 
+// ChecksumMismatches returns the data blocks with mismatching checksums that were found while reading files so far.
+// Mismatches are only collected with ChecksumReport; each block is listed once, in the order the mismatches were
+// found.
+func (cab *Cabinet) ChecksumMismatches() []ChecksumMismatch {
+	return cab.checksums.list()
+}
+
+// readHeader reads CFHEADER and the optional fields following it.
+func (cab *Cabinet) readHeader(reader structureReader, options *Options) (cabinetFileHeader, cabinetFileReservedSizes, error) {
+	var cfHeader cabinetFileHeader
+	var reservedSizes cabinetFileReservedSizes
+	if err := binary.Read(reader, binary.LittleEndian, &cfHeader); err != nil {
+		return cfHeader, reservedSizes, headerError(0, err)
+	}
+
+	if cfHeader.Signature != [4]byte{0x4D, 0x53, 0x43, 0x46} {
+		return cfHeader, reservedSizes, headerError(0, ErrInvalidSignature)
+	}
+
+	if cfHeader.VersionMajor != 1 || cfHeader.VersionMinor > 3 {
+		return cfHeader, reservedSizes, headerError(0, fmt.Errorf("%w: %d.%d", ErrUnsupportedVersion, cfHeader.VersionMajor, cfHeader.VersionMinor))
+	}
+	if err := options.checkHeader(cfHeader); err != nil {
+		return cfHeader, reservedSizes, err
+	}
+	cab.SetIndex = cfHeader.SetIndex
+	cab.SetId = cfHeader.SetId
+
+	cabinetReserve := cfHeader.Flags&cabinetReserveExists != 0
+	if cabinetReserve {
+		if err := binary.Read(reader, binary.LittleEndian, &reservedSizes); err != nil {
+			return cfHeader, reservedSizes, headerError(reader.offset(), err)
+		}
+	}
+	var reservedHeaderBlock = make([]byte, reservedSizes.ReservedHeaderSize)
+	if _, err := io.ReadFull(reader, reservedHeaderBlock); err != nil {
+		return cfHeader, reservedSizes, headerError(reader.offset(), err)
+	}
+	cab.ReservedHeaderBlock = reservedHeaderBlock
+	cab.Reserve = Reserve{
+		Header: int(reservedSizes.ReservedHeaderSize),
+		Folder: int(reservedSizes.ReservedFolderSize),
+		Data:   int(reservedSizes.ReservedDatablockSize),
+	}
+
+	previousCabinet := cfHeader.Flags&previousCabinetExists != 0
+	if previousCabinet {
+		var err error
+		if cab.PreviousFile, err = reader.readString(options.Limits.MaxNameLength); err != nil {
+			return cfHeader, reservedSizes, headerError(reader.offset(), err)
+		}
+		if cab.PreviousDisk, err = reader.readString(options.Limits.MaxNameLength); err != nil {
+			return cfHeader, reservedSizes, headerError(reader.offset(), err)
+		}
+	}
+
+	nextCabinet := cfHeader.Flags&nextCabinetExists != 0
+	if nextCabinet {
+		var err error
+		if cab.NextFile, err = reader.readString(options.Limits.MaxNameLength); err != nil {
+			return cfHeader, reservedSizes, headerError(reader.offset(), err)
+		}
+		if cab.NextDisk, err = reader.readString(options.Limits.MaxNameLength); err != nil {
+			return cfHeader, reservedSizes, headerError(reader.offset(), err)
+		}
+	}
+	return cfHeader, reservedSizes, nil
+}
+
+// resolveFolder returns the folder referenced by a CFFILE folder index. The special indices for files that span
+// multiple cabinets refer to the first or last folder of the cabinet; the folder is marked accordingly.
+func (cab *Cabinet) resolveFolder(fileEntry cabinetFileEntry) (*cabinetFileFolder, error) {
+	index := fileEntry.FolderIndex
+	if len(cab.folders) == 0 {
+		return nil, fileError(fileEntry.index, fileEntry.offset, ErrInvalidFolderReference)
+	}
+	switch index {
+	case folderIndexContinuedFromPrevious:
+		folder := cab.folders[0]
+		folder.continuedFromPrevious = true
+		return folder, nil
+	case folderIndexContinuedToNext:
+		folder := cab.folders[len(cab.folders)-1]
+		folder.continuesToNext = true
+		return folder, nil
+	case folderIndexContinuedPreviousAndNext:
+		// A file that spans a whole cabinet means that the cabinet contains only a single folder
+		folder := cab.folders[0]
+		folder.continuedFromPrevious = true
+		folder.continuesToNext = true
+		return folder, nil
+	}
+	if int(index) >= len(cab.folders) {
+		return nil, fileError(fileEntry.index, fileEntry.offset, ErrInvalidFolderReference)
+	}
+	return cab.folders[index], nil
+}
+
 const (
 	folderIndexContinuedFromPrevious    = 0xFFFD
 	folderIndexContinuedToNext          = 0xFFFE
@@ -190,7 +276,15 @@ type cabinetFileFolder struct {
 	cabinetFileFolderHeader
 	reservedData []byte
 
+	index       int // Index of the folder in its cabinet
 	dataEntries []cabinetFileData
+	options     *Options
+
+	// Set if the folder is continued from the previous cabinet or into the next cabinet of a multi-cabinet set.
+	continuedFromPrevious bool
+	continuesToNext       bool
+	// Set if the folder was read by a StreamReader; its data can only be read through the StreamReader.
+	streamed bool
 }
 
 type cabinetFileEntryHeader struct {
@@ -199,13 +293,16 @@ type cabinetFileEntryHeader struct {
 	FolderIndex                uint16
 	Date                       uint16
 	Time                       uint16
-	Attributes                 uint16
+	Attributes                 Attributes
 	// Followed by fileName, which is a zero-terminated string
 }
 
 type cabinetFileEntry struct {
 	cabinetFileEntryHeader
 	fileName string
+
+	index  int   // Index of the entry in its cabinet
+	offset int64 // Offset of the entry in its cabinet
 }
 
 type cabinetFileDataHeader struct {
@@ -220,9 +317,64 @@ type cabinetFileData struct {
 	cabinetFileDataHeader
 	reservedData   []byte
 	compressedData *io.SectionReader
+
+	// Position of the data block, used for errors
+	folderIndex int
+	blockIndex  int
+	offset      int64
+	volume      *setVolume // Cabinet that contains the data block, set once the cabinets of a set have been merged
+
+	// next is the remainder of a data block that was split across cabinets. It is only set on blocks with
+	// UncompressedBytes == 0 once the cabinets of a set have been merged.
+	next *cabinetFileData
+}
+
+// setVolume identifies a cabinet of a multi-cabinet set.
+type setVolume struct {
+	index int    // SetIndex of the cabinet
+	name  string // File name of the cabinet, if known
+}
+
+// error returns a FormatError for the data block.
+func (d *cabinetFileData) error(err error) error {
+	formatErr := &FormatError{Structure: StructureData, Folder: d.folderIndex, Block: d.blockIndex, File: -1, Offset: d.offset, Volume: -1, Err: truncated(err)}
+	if d.volume != nil {
+		formatErr.Volume, formatErr.VolumeName = d.volume.index, d.volume.name
+	}
+	return formatErr
+}
+
+// uncompressedSize returns the number of uncompressed bytes in the data block, including its continuations.
+func (d *cabinetFileData) uncompressedSize() int {
+	for d.next != nil {
+		d = d.next
+	}
+	return int(d.UncompressedBytes)
+}
+
+// structureReader reads the structures of a cabinet and keeps track of the offset in the cabinet.
+type structureReader interface {
+	io.Reader
+	offset() int64
+	// readString reads a zero-terminated string. If maxLength is not 0, longer strings fail with ErrNameTooLong.
+	readString(maxLength int) (string, error)
+}
+
+// sectionStructureReader is a structureReader for cabinets that are opened with random access.
+type sectionStructureReader struct {
+	*io.SectionReader
 }
 
-func readZeroTerminatedString(reader *io.SectionReader) (string, error) {
+func (s sectionStructureReader) offset() int64 {
+	offset, _ := s.Seek(0, io.SeekCurrent)
+	return offset
+}
+
+func (s sectionStructureReader) readString(maxLength int) (string, error) {
+	return readZeroTerminatedString(s.SectionReader, maxLength)
+}
+
+func readZeroTerminatedString(reader *io.SectionReader, maxLength int) (string, error) {
 	stringStartOffset, _ := reader.Seek(0, io.SeekCurrent)
 
 	var currentBufferSize = 10
@@ -239,6 +391,9 @@ func readZeroTerminatedString(reader *io.SectionReader) (string, error) {
 			}
 		}
 		stringBuffer.Write(buffer[:n])
+		if maxLength > 0 && stringBuffer.Len() > maxLength {
+			return "", ErrNameTooLong
+		}
 		if foundZeroByte {
 			break
 		}
@@ -252,20 +407,24 @@ func readZeroTerminatedString(reader *io.SectionReader) (string, error) {
 	return stringBuffer.String(), nil
 }
 
-func readFolderEntries(reader *io.SectionReader, folderCount uint16, reservedAreaSize uint8) ([]cabinetFileFolder, error) {
+func readFolderEntries(ctx context.Context, reader structureReader, folderCount uint16, reservedAreaSize uint8) ([]cabinetFileFolder, error) {
 	var folders []cabinetFileFolder
 	for i := 0; i < int(folderCount); i++ {
-		var folder cabinetFileFolder
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		folder := cabinetFileFolder{index: i}
+		offset := reader.offset()
 		var folderHeader cabinetFileFolderHeader
 		if err := binary.Read(reader, binary.LittleEndian, &folderHeader); err != nil {
-			return nil, err
+			return nil, folderError(i, offset, err)
 		}
 		folder.cabinetFileFolderHeader = folderHeader
 
 		if reservedAreaSize != 0 {
 			folder.reservedData = make([]byte, reservedAreaSize)
 			if _, err := io.ReadFull(reader, folder.reservedData); err != nil {
-				return nil, err
+				return nil, folderError(i, offset, err)
 			}
 		}
 
@@ -274,20 +433,23 @@ func readFolderEntries(reader *io.SectionReader, folderCount uint16, reservedAre
 	return folders, nil
 }
 
-func readFileEntries(reader *io.SectionReader, fileCount uint16) ([]cabinetFileEntry, error) {
+func readFileEntries(ctx context.Context, reader structureReader, fileCount uint16, maxNameLength int) ([]cabinetFileEntry, error) {
 	var files []cabinetFileEntry
 	for i := 0; i < int(fileCount); i++ {
-		var file cabinetFileEntry
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file := cabinetFileEntry{index: i, offset: reader.offset()}
 
 		var fileHeader cabinetFileEntryHeader
 		if err := binary.Read(reader, binary.LittleEndian, &fileHeader); err != nil {
-			return nil, err
+			return nil, fileError(i, file.offset, err)
 		}
 		file.cabinetFileEntryHeader = fileHeader
 
-		filename, err := readZeroTerminatedString(reader)
+		filename, err := reader.readString(maxNameLength)
 		if err != nil {
-			return nil, err
+			return nil, fileError(i, file.offset, err)
 		}
 		file.fileName = filename
 		files = append(files, file)
@@ -295,21 +457,25 @@ func readFileEntries(reader *io.SectionReader, fileCount uint16) ([]cabinetFileE
 	return files, nil
 }
 
-func readDataEntries(reader *io.SectionReader, dataCount uint16, reservedAreaSize uint8) ([]cabinetFileData, error) {
+func readDataEntries(ctx context.Context, reader *io.SectionReader, folderIndex int, dataCount uint16, reservedAreaSize uint8) ([]cabinetFileData, error) {
 	var dataEntries []cabinetFileData
 	for i := 0; i < int(dataCount); i++ {
-		var dataEntry cabinetFileData
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dataEntry := cabinetFileData{folderIndex: folderIndex, blockIndex: i}
+		dataEntry.offset, _ = reader.Seek(0, io.SeekCurrent)
 
 		var dataEntryHeader cabinetFileDataHeader
 		if err := binary.Read(reader, binary.LittleEndian, &dataEntryHeader); err != nil {
-			return nil, err
+			return nil, dataEntry.error(err)
 		}
 		dataEntry.cabinetFileDataHeader = dataEntryHeader
 
 		if reservedAreaSize != 0 {
 			dataEntry.reservedData = make([]byte, reservedAreaSize)
 			if _, err := io.ReadFull(reader, dataEntry.reservedData); err != nil {
-				return nil, err
+				return nil, dataEntry.error(err)
 			}
 		}
 
@@ -323,7 +489,9 @@ func readDataEntries(reader *io.SectionReader, dataCount uint16, reservedAreaSiz
 	return dataEntries, nil
 }
 
-func parseCabTimestamp(cabDate uint16, cabTime uint16) time.Time {
+// parseCabTimestamp converts the date and time of a CFFILE entry to a time.Time in the given location. It returns
+// false if a field is out of range; time.Date would silently normalize such values.
+func parseCabTimestamp(cabDate uint16, cabTime uint16, location *time.Location) (time.Time, bool) {
 	// See https://docs.microsoft.com/en-us/previous-versions//bb267310(v=vs.85)#cffile
 	// cabDate is ((year–1980) << 9)+(month << 5)+(day)
 	// cabTime is (hour << 11)+(minute << 5)+(seconds/2)
@@ -333,5 +501,12 @@ func parseCabTimestamp(cabDate uint16, cabTime uint16) time.Time {
 	hour := int(cabTime >> 11)
 	minute := int(cabTime>>5) & 0b111111
 	seconds := int(cabTime&0b11111) * 2
-	return time.Date(year, time.Month(month), day, hour, minute, seconds, 0, time.Local)
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || seconds > 59 {
+		return time.Time{}, false
+	}
+	// The day after the last day of the month is normalized to the first day of the next month
+	if day > time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, seconds, 0, location), true
 }
@@ -98,6 +98,68 @@ func TestDecompressLzx(t *testing.T) {
 	}
 }
 
+// quantumHashes are the hashes of the original files in quantum.cab.
+var quantumHashes = map[string]string{
+	"first.txt":  "FE9051B79F297D094B81F31FB4B2D3D2D14BCF79A49B99C0E0EEFD70CC31C401",
+	"second.txt": "D27C3C29F16453DF437CCE344CC1A0F836A1D2F47A781CDA29A3E68CA68E9A75",
+}
+
+// TestDecompressQuantum reads quantum.cab, which was created with the test encoder of the quantum package, so its
+// headers are synthetic (set ID 0x4242, no checksums). The hashes are those of the original files; TestQuantumCabextract
+// checks that libmspack decompresses the same data. A cabinet compressed by MakeCAB or another Quantum implementation
+// is still missing from testdata.
+func TestDecompressQuantum(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/quantum.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHashes := quantumHashes
+	if len(cabFile.Files) != len(expectedHashes) {
+		t.Fatal("expected", len(expectedHashes), "files, got", len(cabFile.Files))
+	}
+	for _, file := range cabFile.Files {
+		sha256Hash := sha256.New()
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(sha256Hash, reader); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%X", sha256Hash.Sum(nil)) != expectedHashes[file.Name] {
+			t.Fatal("hash mismatch on unpacked data for", file.Name)
+		}
+	}
+}
+
+// TestQuantumCabextract checks quantum.cab against the Quantum decompressor of libmspack, which cabextract uses.
+func TestQuantumCabextract(t *testing.T) {
+	if cabextract == "" {
+		t.Skip("cabextract is not installed")
+	}
+	path := "testdata/quantum.cab"
+	listing, err := runCabExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing) != len(quantumHashes) {
+		t.Fatal("unexpected listing", listing)
+	}
+	for _, file := range listing {
+		data, err := getCabExtractFile(path, file.Name)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		if hash := fmt.Sprintf("%X", sha256.Sum256(data)); hash != quantumHashes[file.Name] {
+			t.Fatal("cabextract extracted different data for", file.Name, hash)
+		}
+	}
+}
+
 func TestDecompressMultipleFiles(t *testing.T) {
 	testfileData, err := os.ReadFile("testdata/drivers.cab")
 	if err != nil {
@@ -170,7 +232,6 @@ func FuzzPanic(f *testing.F) {
 var cabextract, _ = exec.LookPath("cabextract")
 
 func FuzzFileCorrectness(f *testing.F) {
-	requireDirectCfFileFollow = true
 	if cabextract == "" {
 		f.Skip("cabextract is not installed")
 	}
@@ -195,7 +256,9 @@ func FuzzFileCorrectness(f *testing.F) {
 		defer tempFile.Close()
 		tempFile.Write(data)
 
-		cabFile, err := Open(bytes.NewReader(data), int64(len(data)))
+		// cabextract assumes that CFFILE structs are immediately after CFFOLDER, which is usually the case, but not
+		// necessary according to the specification.
+		cabFile, err := OpenWithOptions(bytes.NewReader(data), int64(len(data)), &Options{Strict: true})
 		if err != nil {
 			t.Log(err)
 			return
@@ -324,3 +387,36 @@ func getCabExtractFile(path string, file string) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+func TestParseCabTimestamp(t *testing.T) {
+	dosDate := func(year, month, day int) uint16 {
+		return uint16((year-1980)<<9 | month<<5 | day)
+	}
+	dosTime := func(hour, minute, seconds int) uint16 {
+		return uint16(hour<<11 | minute<<5 | seconds/2)
+	}
+	for _, test := range []struct {
+		date, time uint16
+		valid      bool
+	}{
+		{dosDate(2021, 11, 2), dosTime(14, 34, 56), true},
+		{dosDate(2024, 2, 29), dosTime(23, 59, 58), true},
+		{0, 0, false},
+		{dosDate(2021, 0, 1), 0, false},
+		{dosDate(2021, 13, 1), 0, false},
+		{dosDate(2021, 2, 29), 0, false},
+		{dosDate(2021, 2, 31), 0, false},
+		{dosDate(2021, 4, 0), 0, false},
+		{dosDate(2021, 1, 1), dosTime(24, 0, 0), false},
+		{dosDate(2021, 1, 1), dosTime(0, 60, 0), false},
+		{dosDate(2021, 1, 1), dosTime(0, 0, 60), false},
+	} {
+		timestamp, valid := parseCabTimestamp(test.date, test.time, time.UTC)
+		if valid != test.valid {
+			t.Errorf("%04x %04x: expected valid=%v", test.date, test.time, test.valid)
+		}
+		if !valid && !timestamp.IsZero() {
+			t.Errorf("%04x %04x: expected zero time for invalid timestamp, got %v", test.date, test.time, timestamp)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package cab
 
+import "sync"
+
 func computeChecksum(pv []byte, seed uint32) uint32 {
 	csum := seed // Init checksum
 	pb := pv     // Start at front of data block
@@ -66,3 +68,78 @@ func (c *checksumWriter) Flush() {
 		c.remainder = nil
 	}
 }
+
+// verifiedBlocks is the cache of data blocks with matching checksums for Options.CacheVerifiedBlocks.
+type verifiedBlocks struct {
+	blocks sync.Map // *cabinetFileData -> struct{}
+}
+
+// contains reports whether the checksum of entry was already verified. It is false for a nil cache.
+func (v *verifiedBlocks) contains(entry *cabinetFileData) bool {
+	if v == nil {
+		return false
+	}
+	_, ok := v.blocks.Load(entry)
+	return ok
+}
+
+func (v *verifiedBlocks) add(entry *cabinetFileData) {
+	if v != nil {
+		v.blocks.Store(entry, struct{}{})
+	}
+}
+
+// ChecksumMismatch describes a CFDATA block whose checksum does not match its contents.
+type ChecksumMismatch struct {
+	Folder int   // Index of the folder in its cabinet
+	Block  int   // Index of the data block in the folder
+	Offset int64 // Offset of the CFDATA entry in its cabinet
+	// Volume is the index of the cabinet in its multi-cabinet set (iSet) if the set was opened with OpenSet or
+	// OpenFS, or -1 otherwise.
+	Volume int
+	// VolumeName is the name of that cabinet in the file system if the set was opened with OpenFS.
+	VolumeName string
+	Expected   uint32
+	Actual     uint32
+}
+
+// checksumReport collects the checksum mismatches of a cabinet for ChecksumReport.
+type checksumReport struct {
+	mutex      sync.Mutex
+	seen       map[*cabinetFileData]bool
+	mismatches []ChecksumMismatch
+}
+
+// add records a mismatch of entry; mismatches of blocks that were already recorded are ignored.
+func (r *checksumReport) add(entry *cabinetFileData, actual uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.seen[entry] {
+		return
+	}
+	if r.seen == nil {
+		r.seen = map[*cabinetFileData]bool{}
+	}
+	r.seen[entry] = true
+	mismatch := ChecksumMismatch{
+		Folder:   entry.folderIndex,
+		Block:    entry.blockIndex,
+		Offset:   entry.offset,
+		Volume:   -1,
+		Expected: entry.Checksum,
+		Actual:   actual,
+	}
+	if entry.volume != nil {
+		mismatch.Volume, mismatch.VolumeName = entry.volume.index, entry.volume.name
+	}
+	r.mismatches = append(r.mismatches, mismatch)
+}
+
+func (r *checksumReport) list() []ChecksumMismatch {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]ChecksumMismatch(nil), r.mismatches...)
+}
@@ -0,0 +1,99 @@
+package cab
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentOpen reads files of a cabinet in several goroutines at once. Run with -race to detect data races.
+func TestConcurrentOpen(t *testing.T) {
+	for _, name := range []string{"drivers.cab", "lzx.cab", "quantum.cab"} {
+		for _, opts := range []*Options{nil, {CacheVerifiedBlocks: true}, {Checksums: ChecksumReport}} {
+			testfileData, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := map[*File][]byte{}
+			if err := cabFile.Walk(func(file *File, reader io.Reader) error {
+				expected[file], err = io.ReadAll(reader)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Decompressing large folders for every file is slow with -race, use a few files spread across the cabinet
+			var files []*File
+			for i := 0; i < len(cabFile.Files); i += len(cabFile.Files)/8 + 1 {
+				files = append(files, cabFile.Files[i])
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 4*len(files))
+			for i := 0; i < 4; i++ {
+				for _, file := range files {
+					wg.Add(1)
+					go func(file *File, randomAccess bool) {
+						defer wg.Done()
+						var reader io.ReadCloser
+						var err error
+						if randomAccess {
+							reader, err = file.OpenReaderAt()
+						} else {
+							reader, err = file.Open()
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+						defer reader.Close()
+						data, err := io.ReadAll(reader)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if !bytes.Equal(data, expected[file]) {
+							t.Error(name, "content mismatch for", file.Name)
+						}
+					}(file, i%2 == 1)
+				}
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(name, err)
+			}
+			if len(cabFile.ChecksumMismatches()) != 0 {
+				t.Fatal(name, cabFile.ChecksumMismatches())
+			}
+		}
+	}
+}
+
+func TestCacheVerifiedBlocks(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), &Options{CacheVerifiedBlocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	entry := &cabFile.folders[0].dataEntries[0]
+	if !cabFile.folders[0].options.verified.contains(entry) {
+		t.Fatal("verified block was not cached")
+	}
+}
@@ -0,0 +1,60 @@
+package cab
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestOpenContextCanceled(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OpenContext(ctx, bytes.NewReader(testfileData), int64(len(testfileData)), nil); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+}
+
+func TestFileOpenContextCanceled(t *testing.T) {
+	for _, name := range []string{"drivers.cab", "lzx.cab", "quantum.cab"} {
+		testfileData, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := cabFile.Files[len(cabFile.Files)-1]
+
+		// Canceled before skipping to the file
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := file.OpenContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatal(name, "expected context.Canceled, got", err)
+		}
+
+		// Canceled while reading
+		ctx, cancel = context.WithCancel(context.Background())
+		reader, err := file.OpenContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if _, err := io.ReadAll(reader); !errors.Is(err, context.Canceled) {
+			t.Fatal(name, "expected context.Canceled, got", err)
+		}
+		if err := reader.Close(); err != nil && !errors.Is(err, context.Canceled) {
+			t.Fatal(err)
+		}
+	}
+}
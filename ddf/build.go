@@ -0,0 +1,327 @@
+package ddf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/secDre4mer/go-cab"
+)
+
+// BuildOptions configures Layout.Build.
+type BuildOptions struct {
+	// SourceDir is the directory that relative source paths are resolved against. It defaults to the current
+	// directory.
+	SourceDir string
+	// OutputDir is the directory that contains the disk directories and the reports. It defaults to the current
+	// directory.
+	OutputDir string
+	// Reports enables the report file (RptFileName) and, if GenerateInf is set, the INF file (InfFileName). Both are
+	// written to OutputDir.
+	Reports bool
+}
+
+// Result summarizes the output of Layout.Build.
+type Result struct {
+	Cabinets    []string // Paths of the cabinets, in the order of the set
+	Files       int      // Number of files, in cabinets or copied to the disk directory
+	BytesBefore int64    // Size of the files that were stored in cabinets
+	BytesAfter  int64    // Size of the cabinets
+}
+
+// buildFile is a file of the layout after it was stored.
+type buildFile struct {
+	*File
+	size    int64
+	cabinet int // Number of the first cabinet that lists the file, or 0 if the file is not stored in a cabinet
+}
+
+// Build creates the cabinets of the layout. Each cabinet is stored in its own disk directory. Files with Cabinet=off
+// are copied to the directory of the first disk.
+//
+// Modification times before 1980, which cannot be stored in a cabinet, are replaced by 1980-01-01.
+func (l *Layout) Build(options *BuildOptions) (*Result, error) {
+	if options == nil {
+		options = &BuildOptions{}
+	}
+	start := time.Now()
+	result := &Result{}
+	diskDir := func(disk int) string {
+		dir := strings.ReplaceAll(l.DiskDirectoryTemplate, "*", strconv.Itoa(disk))
+		return filepath.Join(options.OutputDir, filepath.FromSlash(strings.ReplaceAll(dir, `\`, "/")))
+	}
+	createCabinet := func(name string) (io.WriteCloser, error) {
+		dir := diskDir(len(result.Cabinets) + 1)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		cabinetPath := filepath.Join(dir, name)
+		output, err := os.Create(cabinetPath)
+		if err != nil {
+			return nil, err
+		}
+		result.Cabinets = append(result.Cabinets, cabinetPath)
+		return output, nil
+	}
+
+	files := make([]buildFile, len(l.Files))
+	var cabinetFiles []*buildFile
+	for i := range l.Files {
+		files[i].File = &l.Files[i]
+		if l.Files[i].Cabinet {
+			cabinetFiles = append(cabinetFiles, &files[i])
+		}
+	}
+	if len(cabinetFiles) > 0 {
+		if err := l.writeCabinets(options, cabinetFiles, createCabinet); err != nil {
+			return nil, err
+		}
+	}
+	for i := range files {
+		if files[i].Cabinet {
+			continue
+		}
+		size, err := copyFile(options.SourceDir, files[i].Source, diskDir(1), files[i].Destination)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", files[i].Line, err)
+		}
+		files[i].size = size
+	}
+
+	result.Files = len(files)
+	for _, file := range cabinetFiles {
+		result.BytesBefore += file.size
+	}
+	for _, cabinetPath := range result.Cabinets {
+		info, err := os.Stat(cabinetPath)
+		if err != nil {
+			return nil, err
+		}
+		result.BytesAfter += info.Size()
+	}
+
+	if options.Reports {
+		if options.OutputDir != "" {
+			if err := os.MkdirAll(options.OutputDir, 0o755); err != nil {
+				return nil, err
+			}
+		}
+		if err := l.writeReport(filepath.Join(options.OutputDir, l.RptFileName), result, start); err != nil {
+			return nil, err
+		}
+		if l.GenerateInf {
+			if err := l.writeInf(filepath.Join(options.OutputDir, l.InfFileName), result, files); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// writeCabinets stores the files in a single cabinet or, if MaxCabinetSize is set, in a cabinet set.
+func (l *Layout) writeCabinets(options *BuildOptions, files []*buildFile, create func(name string) (io.WriteCloser, error)) error {
+	var writer *cab.Writer
+	var output io.WriteCloser
+	if l.MaxCabinetSize == 0 {
+		var err error
+		output, err = create(strings.ReplaceAll(l.CabinetNameTemplate, "*", "1"))
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+		writer = cab.NewWriter(output)
+	} else {
+		var err error
+		writer, err = cab.NewSetWriter(cab.SetOptions{
+			MaxSize:      l.MaxCabinetSize,
+			NameTemplate: l.CabinetNameTemplate,
+			DiskTemplate: l.DiskLabelTemplate,
+			Create:       create,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writer.SetReserve(l.Reserve); err != nil {
+		return err
+	}
+
+	var folderSize int64
+	for _, file := range files {
+		if file.NewFolder || (file.FolderSizeThreshold > 0 && folderSize >= file.FolderSizeThreshold) {
+			if err := writer.NewFolder(file.Compression); err != nil {
+				return err
+			}
+			folderSize = 0
+		}
+		size, err := addFile(writer, options.SourceDir, file.File)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", file.Line, err)
+		}
+		file.size = size
+		folderSize += size
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	for i, cabinet := range writer.FileCabinets() {
+		files[i].cabinet = cabinet + 1
+	}
+	if output != nil {
+		return output.Close()
+	}
+	return nil
+}
+
+// addFile stores a source file in the cabinet.
+func addFile(writer *cab.Writer, sourceDir string, file *File) (int64, error) {
+	source, err := os.Open(sourcePath(sourceDir, file.Source))
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return 0, err
+	}
+	attributes := cab.AttributeArch
+	if info.Mode().Perm()&0o200 == 0 {
+		attributes |= cab.AttributeReadOnly
+	}
+	output, err := writer.Create(cab.FileHeader{
+		Name:       file.Destination,
+		Modified:   clampTime(info.ModTime()),
+		Attributes: attributes,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(output, source)
+}
+
+// copyFile copies a source file that is not stored in a cabinet to the disk directory.
+func copyFile(sourceDir, sourceName, dir, destination string) (int64, error) {
+	source, err := os.Open(sourcePath(sourceDir, sourceName))
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return 0, err
+	}
+	outputPath := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(destination, `\`, "/")))
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return 0, err
+	}
+	output, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(output, source)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, os.Chtimes(outputPath, info.ModTime(), info.ModTime())
+}
+
+// sourcePath converts a source path of a directive file to a local path.
+func sourcePath(sourceDir, name string) string {
+	if isAbsolute(name) {
+		return filepath.FromSlash(strings.ReplaceAll(name, `\`, "/"))
+	}
+	return filepath.Join(sourceDir, filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+}
+
+func clampTime(t time.Time) time.Time {
+	if t.Year() < 1980 {
+		return time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+// writeReport writes a report in the format of MakeCAB's setup.rpt.
+func (l *Layout) writeReport(name string, result *Result, start time.Time) error {
+	elapsed := time.Since(start).Seconds()
+	ratio := 0.0
+	if result.BytesBefore > 0 {
+		ratio = 100 * float64(result.BytesAfter) / float64(result.BytesBefore)
+	}
+	var report strings.Builder
+	fmt.Fprintf(&report, "MakeCAB Report: %s\r\n\r\n", start.Format("Mon Jan 02 15:04:05 2006"))
+	fmt.Fprintf(&report, "Total files:     %13s\r\n", groupDigits(int64(result.Files)))
+	fmt.Fprintf(&report, "Bytes before:    %13s\r\n", groupDigits(result.BytesBefore))
+	fmt.Fprintf(&report, "Bytes after:     %13s\r\n", groupDigits(result.BytesAfter))
+	fmt.Fprintf(&report, "After/Before:    %13.2f%% compression\r\n", ratio)
+	fmt.Fprintf(&report, "Time:            %13.2f seconds\r\n", elapsed)
+	return os.WriteFile(name, []byte(report.String()), 0o644)
+}
+
+// writeInf writes the disk, cabinet and file lists in the format of MakeCAB's setup.inf.
+func (l *Layout) writeInf(name string, result *Result, files []buildFile) error {
+	disks := len(result.Cabinets)
+	if disks == 0 {
+		disks = 1
+	}
+	var inf strings.Builder
+	writeLine := func(format string, replacements ...string) {
+		inf.WriteString(strings.NewReplacer(replacements...).Replace(format))
+		inf.WriteString("\r\n")
+	}
+	label := func(number int) string {
+		return strings.ReplaceAll(l.DiskLabelTemplate, "*", strconv.Itoa(number))
+	}
+
+	writeLine(l.InfFormat.DiskHeader)
+	for i := 1; i <= disks; i++ {
+		writeLine(l.InfFormat.DiskLine, "*disk#*", strconv.Itoa(i), "*label*", label(i))
+	}
+	inf.WriteString("\r\n")
+	writeLine(l.InfFormat.CabinetHeader)
+	for i, cabinetPath := range result.Cabinets {
+		writeLine(l.InfFormat.CabinetLine,
+			"*cab#*", strconv.Itoa(i+1), "*disk#*", strconv.Itoa(i+1), "*label*", label(i+1),
+			"*cabfile*", filepath.Base(cabinetPath))
+	}
+	inf.WriteString("\r\n")
+	writeLine(l.InfFormat.FileHeader)
+	for i, file := range files {
+		if !file.Inf {
+			continue
+		}
+		diskNumber := file.cabinet
+		if diskNumber == 0 {
+			diskNumber = 1
+		}
+		cabinetFile := ""
+		if file.cabinet > 0 {
+			cabinetFile = filepath.Base(result.Cabinets[file.cabinet-1])
+		}
+		writeLine(l.InfFormat.FileLine,
+			"*disk#*", strconv.Itoa(diskNumber), "*label*", label(diskNumber),
+			"*cab#*", strconv.Itoa(file.cabinet), "*cabfile*", cabinetFile,
+			"*file#*", strconv.Itoa(i+1), "*file*", file.Destination, "*size*", strconv.FormatInt(file.size, 10))
+	}
+	return os.WriteFile(name, []byte(inf.String()), 0o644)
+}
+
+// groupDigits formats a number with thousands separators, like MakeCAB's reports.
+func groupDigits(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return grouped.String()
+}
@@ -0,0 +1,226 @@
+package ddf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/secDre4mer/go-cab"
+)
+
+// writeSources creates the source files of a test build.
+func writeSources(t *testing.T, files map[string][]byte) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func build(t *testing.T, directives string, sources map[string][]byte) (*Result, string) {
+	layout, err := Parse(strings.NewReader(directives), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	result, err := layout.Build(&BuildOptions{SourceDir: writeSources(t, sources), OutputDir: output, Reports: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, output
+}
+
+func readFiles(t *testing.T, cabFile *cab.Cabinet) map[string][]byte {
+	contents := map[string][]byte{}
+	for _, file := range cabFile.Files {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[file.Name] = data
+	}
+	return contents
+}
+
+func TestBuild(t *testing.T) {
+	sources := map[string][]byte{
+		"readme.txt": []byte("Read me"),
+		"data.bin":   bytes.Repeat([]byte("data "), 10000),
+		"setup.exe":  []byte("MZ"),
+	}
+	result, output := build(t, `.Set MaxDiskSize=0
+.Set CabinetNameTemplate=test*.cab
+readme.txt
+.Set CompressionType=LZX
+data.bin "data\data.bin" /inf=no
+.Set Cabinet=off
+setup.exe
+`, sources)
+	if len(result.Cabinets) != 1 || result.Files != 3 || result.BytesBefore != 50007 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	cabPath := filepath.Join(output, "disk1", "test1.cab")
+	if result.Cabinets[0] != cabPath {
+		t.Fatal("unexpected cabinet", result.Cabinets[0])
+	}
+	data, err := os.ReadFile(cabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != result.BytesAfter {
+		t.Fatal("unexpected cabinet size", len(data))
+	}
+	cabFile, err := cab.Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The compression change starts a new folder; cFolders is at offset 26 of the header
+	if folders := binary.LittleEndian.Uint16(data[26:]); folders != 2 {
+		t.Fatal("unexpected number of folders", folders)
+	}
+	contents := readFiles(t, cabFile)
+	if len(contents) != 2 || !bytes.Equal(contents["readme.txt"], sources["readme.txt"]) ||
+		!bytes.Equal(contents[`data\data.bin`], sources["data.bin"]) {
+		t.Fatal("unexpected cabinet contents", cabFile.Files)
+	}
+	if copied, err := os.ReadFile(filepath.Join(output, "disk1", "setup.exe")); err != nil || !bytes.Equal(copied, sources["setup.exe"]) {
+		t.Fatal("setup.exe was not copied", err)
+	}
+
+	inf, err := os.ReadFile(filepath.Join(output, "setup.inf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedInf := "[disk list]\r\n1,Disk 1\r\n\r\n" +
+		"[cabinet list]\r\n1,1,test1.cab\r\n\r\n" +
+		"[file list]\r\n1,1,readme.txt,7\r\n1,0,setup.exe,2\r\n"
+	if string(inf) != expectedInf {
+		t.Fatalf("unexpected INF file %q", inf)
+	}
+	report, err := os.ReadFile(filepath.Join(output, "setup.rpt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Total files:", "Bytes before:           50,007\r\n", "Bytes after:", "% compression"} {
+		if !strings.Contains(string(report), line) {
+			t.Fatalf("report %q does not contain %q", report, line)
+		}
+	}
+}
+
+func TestBuildSet(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	sources := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		data := make([]byte, 30000)
+		random.Read(data)
+		sources[name] = data
+	}
+	result, output := build(t, `.Set MaxDiskSize=40000
+.Set InfFileLineFormat=*file#*:*cab#*:*cabfile*:*file*
+.Set Compress=off
+a.bin
+b.bin
+c.bin
+`, sources)
+	if len(result.Cabinets) != 3 {
+		t.Fatal("unexpected number of cabinets", result.Cabinets)
+	}
+	var readers []io.ReaderAt
+	var sizes []int64
+	for i, cabPath := range result.Cabinets {
+		if cabPath != filepath.Join(output, "disk"+string(rune('1'+i)), string(rune('1'+i))+".cab") {
+			t.Fatal("unexpected cabinet", cabPath)
+		}
+		info, err := os.Stat(cabPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 40000 {
+			t.Fatal("cabinet is too large", info.Size())
+		}
+		cabinet, err := os.Open(cabPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cabinet.Close()
+		readers = append(readers, cabinet)
+		sizes = append(sizes, info.Size())
+	}
+	set, err := cab.OpenSet(readers, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := readFiles(t, set)
+	for name, data := range sources {
+		if !bytes.Equal(contents[name], data) {
+			t.Fatal("unexpected contents of", name)
+		}
+	}
+
+	inf, err := os.ReadFile(filepath.Join(output, "setup.inf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c.bin starts in a data block that is split between the first and second cabinet
+	expectedInf := "[disk list]\r\n1,Disk 1\r\n2,Disk 2\r\n3,Disk 3\r\n\r\n" +
+		"[cabinet list]\r\n1,1,1.cab\r\n2,2,2.cab\r\n3,3,3.cab\r\n\r\n" +
+		"[file list]\r\n1:1:1.cab:a.bin\r\n2:1:1.cab:b.bin\r\n3:1:1.cab:c.bin\r\n"
+	if string(inf) != expectedInf {
+		t.Fatalf("unexpected INF file %q", inf)
+	}
+}
+
+func TestBuildFixedName(t *testing.T) {
+	sources := map[string][]byte{"readme.txt": []byte("Read me")}
+	// The default MaxDiskSize of 1.44M makes a set, but a single cabinet needs no * in its name
+	result, output := build(t, ".Set CabinetNameTemplate=product.cab\nreadme.txt\n", sources)
+	cabPath := filepath.Join(output, "disk1", "product.cab")
+	if len(result.Cabinets) != 1 || result.Cabinets[0] != cabPath {
+		t.Fatal("unexpected cabinets", result.Cabinets)
+	}
+	data, err := os.ReadFile(cabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := cab.Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cabFile.NextFile != "" || !bytes.Equal(readFiles(t, cabFile)["readme.txt"], sources["readme.txt"]) {
+		t.Fatal("unexpected cabinet", cabFile.MultiCabinetInfo, cabFile.Files)
+	}
+
+	// More than one cabinet needs a * in the name
+	layout, err := Parse(strings.NewReader(".Set CabinetNameTemplate=product.cab\n.Set MaxDiskSize=1000\n"+
+		".Set Compress=off\ndata.bin\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceDir := writeSources(t, map[string][]byte{"data.bin": make([]byte, 5000)})
+	if _, err := layout.Build(&BuildOptions{SourceDir: sourceDir, OutputDir: t.TempDir()}); err == nil {
+		t.Fatal("expected error for several cabinets without * in the name")
+	}
+}
+
+func TestBuildMissingSource(t *testing.T) {
+	layout, err := Parse(strings.NewReader("missing.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := layout.Build(&BuildOptions{SourceDir: t.TempDir(), OutputDir: t.TempDir()}); err == nil {
+		t.Fatal("expected error for missing source file")
+	}
+}
@@ -0,0 +1,492 @@
+// Package ddf reads MakeCAB directive files (DDF) and creates the cabinets that they describe.
+//
+// The directives .Set, .Define, .Option Explicit and .New Folder are supported, as well as file lines with a
+// destination name and parameters such as /inf=no. Variables are referenced as %name%; %% is a literal percent sign.
+// Variable names are case-insensitive.
+package ddf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/secDre4mer/go-cab"
+)
+
+// Layout describes the cabinets and files of a directive file.
+type Layout struct {
+	// CabinetNameTemplate is the file name of the cabinets; "*" is replaced by the number of the cabinet. A template
+	// without "*" is only valid if all files fit into a single cabinet.
+	CabinetNameTemplate string
+	// DiskDirectoryTemplate is the directory of each disk, relative to the output directory; "*" is replaced by the
+	// number of the disk. Every cabinet is stored on its own disk.
+	DiskDirectoryTemplate string
+	// DiskLabelTemplate is the label of each disk, with "*" replaced like in DiskDirectoryTemplate.
+	DiskLabelTemplate string
+	// MaxCabinetSize is the maximum size of a cabinet, or 0 if the files are stored in a single cabinet.
+	MaxCabinetSize int64
+	// Reserve contains the sizes of the reserved areas of each cabinet (ReservePerCabinetSize, ReservePerFolderSize
+	// and ReservePerDataBlockSize).
+	Reserve cab.Reserve
+
+	// GenerateInf enables the INF file when reports are written, see BuildOptions.Reports.
+	GenerateInf bool
+	InfFileName string
+	RptFileName string
+	InfFormat   InfFormat
+
+	Files     []File
+	Variables map[string]string // Values of all variables at the end of the file, with lower case names
+
+	userDefined  map[string]bool
+	explicit     bool // .Option Explicit was used
+	cabinetFiles bool // A file was stored in a cabinet, so the cabinet variables can no longer change
+	newFolder    bool // .New Folder was used since the last file
+}
+
+// InfFormat contains the headers and line formats of the INF file. In the line formats, *disk#*, *label*, *cab#*,
+// *cabfile*, *file#*, *file* and *size* are replaced by the number and label of the disk, the number and file name
+// of the cabinet, and the number, destination name and size of the file.
+type InfFormat struct {
+	DiskHeader, DiskLine       string
+	CabinetHeader, CabinetLine string
+	FileHeader, FileLine       string
+}
+
+// File is a file line of a directive file, with the variables that apply to the file.
+type File struct {
+	Line        int
+	Source      string // Path of the source file, including SourceDir
+	Destination string // Name of the file in the cabinet, including DestinationDir
+	// Cabinet is false if the file is copied to the disk directory instead of being stored in a cabinet.
+	Cabinet     bool
+	Compression cab.Compression
+	// NewFolder is set if the file starts a new folder, because of .New Folder or because the compression changed.
+	NewFolder bool
+	// FolderSizeThreshold is the uncompressed size after which a new folder is started, or 0.
+	FolderSizeThreshold int64
+	// Inf is false if the file is not listed in the INF file (/inf=no).
+	Inf bool
+	// Parameters contains all parameters of the file line, with lower case names.
+	Parameters map[string]string
+}
+
+// defaults contains the supported standard variables and their default values.
+var defaults = map[string]string{
+	"cabinet":                 "on",
+	"cabinetnametemplate":     "*.cab",
+	"compress":                "on",
+	"compressiontype":         "MSZIP",
+	"compressionmemory":       "18",
+	"destinationdir":          "",
+	"diskdirectorytemplate":   "disk*",
+	"disklabeltemplate":       "Disk *",
+	"foldersizethreshold":     "0",
+	"generateinf":             "on",
+	"inffilename":             "setup.inf",
+	"rptfilename":             "setup.rpt",
+	"maxcabinetsize":          "0",
+	"maxdisksize":             "1.44M",
+	"maxerrors":               "20", // Parsing stops at the first error
+	"reservepercabinetsize":   "0",
+	"reserveperfoldersize":    "0",
+	"reserveperdatablocksize": "0",
+	"sourcedir":               "",
+	"uniquefiles":             "on",
+	"infdiskheader":           "[disk list]",
+	"infdisklineformat":       "*disk#*,*label*",
+	"infcabinetheader":        "[cabinet list]",
+	"infcabinetlineformat":    "*cab#*,*disk#*,*cabfile*",
+	"inffileheader":           "[file list]",
+	"inffilelineformat":       "*disk#*,*cab#*,*file*,*size*",
+}
+
+// unsupported contains standard MakeCAB variables that change the output in a way that is not supported.
+var unsupported = map[string]bool{
+	"cabinetfilecountthreshold": true,
+	"folderfilecountthreshold":  true,
+	"maxdiskfilecount":          true,
+	"clustersize":               true,
+}
+
+// cabinetVariables are the variables that apply to all cabinets. They cannot change once a file was stored in a
+// cabinet.
+var cabinetVariables = map[string]bool{
+	"cabinetnametemplate":     true,
+	"diskdirectorytemplate":   true,
+	"disklabeltemplate":       true,
+	"maxcabinetsize":          true,
+	"maxdisksize":             true,
+	"reservepercabinetsize":   true,
+	"reserveperfoldersize":    true,
+	"reserveperdatablocksize": true,
+}
+
+// Standard disk sizes that MaxDiskSize accepts in place of a number.
+var diskSizes = map[string]int64{
+	"cdrom": 681984000,
+	"1.44m": 1457664,
+	"1.2m":  1213952,
+	"720k":  730112,
+	"360k":  362496,
+}
+
+// Parse reads a directive file. defines contains variables that are defined before the first line, like
+// "makecab /D name=value"; it may be nil.
+func Parse(reader io.Reader, defines map[string]string) (*Layout, error) {
+	layout := &Layout{Variables: map[string]string{}, userDefined: map[string]bool{}}
+	for name, value := range defaults {
+		layout.Variables[name] = value
+	}
+	for name, value := range defines {
+		if err := layout.set(name, value, false); err != nil {
+			return nil, err
+		}
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if err := layout.parseLine(lineNumber, scanner.Text()); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := layout.finish(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+func (l *Layout) parseLine(lineNumber int, line string) error {
+	line, err := l.substitute(stripComment(line))
+	if err != nil {
+		return err
+	}
+	tokens, err := tokenize(line)
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+	if !strings.HasPrefix(tokens[0], ".") {
+		return l.parseFile(lineNumber, tokens)
+	}
+
+	// The arguments of .Set and .Define are not split at spaces
+	arguments := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), tokens[0]))
+	switch strings.ToLower(tokens[0]) {
+	case ".set", ".define":
+		name, value, found := strings.Cut(arguments, "=")
+		if !found {
+			return fmt.Errorf("expected %s name=value", tokens[0])
+		}
+		values, err := tokenize(value)
+		if err != nil {
+			return err
+		}
+		if len(values) > 1 {
+			return fmt.Errorf("value %q must be quoted", strings.TrimSpace(value))
+		}
+		value = ""
+		if len(values) == 1 {
+			value = values[0]
+		}
+		return l.set(strings.TrimSpace(name), value, strings.EqualFold(tokens[0], ".define"))
+	case ".option":
+		if len(tokens) != 2 || !strings.EqualFold(tokens[1], "explicit") {
+			return fmt.Errorf("unsupported option %q", strings.Join(tokens[1:], " "))
+		}
+		l.explicit = true
+		return nil
+	case ".new":
+		if len(tokens) != 2 || !strings.EqualFold(tokens[1], "folder") {
+			return fmt.Errorf("unsupported directive .New %s", strings.Join(tokens[1:], " "))
+		}
+		l.newFolder = true
+		return nil
+	default:
+		return fmt.Errorf("unsupported directive %s", tokens[0])
+	}
+}
+
+// set sets a variable. define is set for .Define, which defines a user variable.
+func (l *Layout) set(name, value string, define bool) error {
+	key := strings.ToLower(name)
+	if key == "" || strings.ContainsAny(key, "% \t\"") {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	if unsupported[key] {
+		return fmt.Errorf("variable %s is not supported", name)
+	}
+	_, standard := defaults[key]
+	switch {
+	case define && standard:
+		return fmt.Errorf("cannot define standard variable %s", name)
+	case define:
+		l.userDefined[key] = true
+	case !standard && !l.userDefined[key]:
+		if l.explicit {
+			return fmt.Errorf("variable %s is not defined", name)
+		}
+		l.userDefined[key] = true
+	}
+	if cabinetVariables[key] && l.cabinetFiles && value != l.Variables[key] {
+		return fmt.Errorf("variable %s cannot change after the first file", name)
+	}
+	l.Variables[key] = value
+	return nil
+}
+
+// substitute replaces references to variables.
+func (l *Layout) substitute(line string) (string, error) {
+	var result strings.Builder
+	for {
+		start := strings.IndexByte(line, '%')
+		if start < 0 {
+			result.WriteString(line)
+			return result.String(), nil
+		}
+		end := strings.IndexByte(line[start+1:], '%')
+		if end < 0 {
+			return "", errors.New("unterminated variable reference")
+		}
+		end += start + 1
+		result.WriteString(line[:start])
+		if name := line[start+1 : end]; name == "" {
+			result.WriteByte('%')
+		} else if value, ok := l.Variables[strings.ToLower(name)]; ok {
+			result.WriteString(value)
+		} else {
+			return "", fmt.Errorf("variable %s is not defined", name)
+		}
+		line = line[end+1:]
+	}
+}
+
+// stripComment removes a comment, which starts at a semicolon outside of quotes. Comments are removed before
+// variables are substituted, so they may contain a percent sign.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// tokenize splits a line at spaces. Double quotes group a token, and "" within quotes is a literal quote.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		var token strings.Builder
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			if line[i] != '"' {
+				token.WriteByte(line[i])
+				i++
+				continue
+			}
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unterminated quote")
+				}
+				if line[i] == '"' {
+					if i+1 < len(line) && line[i+1] == '"' {
+						token.WriteByte('"')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				token.WriteByte(line[i])
+			}
+		}
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+func (l *Layout) parseFile(lineNumber int, tokens []string) error {
+	file := File{
+		Line:       lineNumber,
+		Source:     tokens[0],
+		Inf:        true,
+		Parameters: map[string]string{},
+	}
+	parameters := tokens[1:]
+	destination := path.Base(strings.ReplaceAll(file.Source, `\`, "/"))
+	if len(parameters) > 0 && !strings.HasPrefix(parameters[0], "/") {
+		destination = parameters[0]
+		parameters = parameters[1:]
+	}
+	for _, parameter := range parameters {
+		name, value, found := strings.Cut(strings.TrimPrefix(parameter, "/"), "=")
+		if !strings.HasPrefix(parameter, "/") || !found || name == "" {
+			return fmt.Errorf("invalid parameter %q", parameter)
+		}
+		file.Parameters[strings.ToLower(name)] = value
+	}
+	if inf, ok := file.Parameters["inf"]; ok {
+		var err error
+		if file.Inf, err = parseBool("/inf", inf); err != nil {
+			return err
+		}
+	}
+
+	if sourceDir := l.Variables["sourcedir"]; sourceDir != "" && !isAbsolute(file.Source) {
+		file.Source = strings.TrimRight(sourceDir, `\/`) + "/" + file.Source
+	}
+	if destinationDir := l.Variables["destinationdir"]; destinationDir != "" {
+		destination = strings.TrimRight(destinationDir, `\/`) + `\` + destination
+	}
+	file.Destination = destination
+
+	var err error
+	if file.Cabinet, err = parseBool("Cabinet", l.Variables["cabinet"]); err != nil {
+		return err
+	}
+	if file.Compression, err = l.compression(); err != nil {
+		return err
+	}
+	if file.FolderSizeThreshold, err = parseSize("FolderSizeThreshold", l.Variables["foldersizethreshold"]); err != nil {
+		return err
+	}
+	unique, err := parseBool("UniqueFiles", l.Variables["uniquefiles"])
+	if err != nil {
+		return err
+	}
+	if unique {
+		for _, other := range l.Files {
+			if strings.EqualFold(other.Destination, file.Destination) {
+				return fmt.Errorf("duplicate destination name %s, see line %d", file.Destination, other.Line)
+			}
+		}
+	}
+
+	if file.Cabinet {
+		// A folder has a single compression type
+		previous := l.lastCabinetFile()
+		file.NewFolder = l.newFolder || previous == nil || previous.Compression != file.Compression
+		l.newFolder = false
+		l.cabinetFiles = true
+	}
+	l.Files = append(l.Files, file)
+	return nil
+}
+
+func (l *Layout) lastCabinetFile() *File {
+	for i := len(l.Files) - 1; i >= 0; i-- {
+		if l.Files[i].Cabinet {
+			return &l.Files[i]
+		}
+	}
+	return nil
+}
+
+// compression returns the compression that Compress, CompressionType and CompressionMemory select.
+func (l *Layout) compression() (cab.Compression, error) {
+	compress, err := parseBool("Compress", l.Variables["compress"])
+	if err != nil || !compress {
+		return cab.CompressionNone, err
+	}
+	switch compressionType := l.Variables["compressiontype"]; strings.ToLower(compressionType) {
+	case "mszip":
+		return cab.CompressionMSZIP, nil
+	case "lzx":
+		memory, err := strconv.Atoi(l.Variables["compressionmemory"])
+		if err != nil || memory < 15 || memory > 21 {
+			return 0, fmt.Errorf("invalid CompressionMemory %q for LZX", l.Variables["compressionmemory"])
+		}
+		return cab.CompressionLZX(memory), nil
+	default:
+		return 0, fmt.Errorf("unsupported CompressionType %q", compressionType)
+	}
+}
+
+// finish reads the variables that apply to all cabinets.
+func (l *Layout) finish() error {
+	l.CabinetNameTemplate = l.Variables["cabinetnametemplate"]
+	l.DiskDirectoryTemplate = l.Variables["diskdirectorytemplate"]
+	l.DiskLabelTemplate = l.Variables["disklabeltemplate"]
+	l.InfFileName = l.Variables["inffilename"]
+	l.RptFileName = l.Variables["rptfilename"]
+	l.InfFormat = InfFormat{
+		DiskHeader:    l.Variables["infdiskheader"],
+		DiskLine:      l.Variables["infdisklineformat"],
+		CabinetHeader: l.Variables["infcabinetheader"],
+		CabinetLine:   l.Variables["infcabinetlineformat"],
+		FileHeader:    l.Variables["inffileheader"],
+		FileLine:      l.Variables["inffilelineformat"],
+	}
+	var err error
+	if l.GenerateInf, err = parseBool("GenerateInf", l.Variables["generateinf"]); err != nil {
+		return err
+	}
+	if l.MaxCabinetSize, err = parseSize("MaxCabinetSize", l.Variables["maxcabinetsize"]); err != nil {
+		return err
+	}
+	maxDiskSize, err := parseSize("MaxDiskSize", l.Variables["maxdisksize"])
+	if err != nil {
+		return err
+	}
+	if l.MaxCabinetSize == 0 || (maxDiskSize != 0 && maxDiskSize < l.MaxCabinetSize) {
+		l.MaxCabinetSize = maxDiskSize
+	}
+	for _, reserve := range []struct {
+		name  string
+		value *int
+	}{
+		{"ReservePerCabinetSize", &l.Reserve.Header},
+		{"ReservePerFolderSize", &l.Reserve.Folder},
+		{"ReservePerDataBlockSize", &l.Reserve.Data},
+	} {
+		size, err := parseSize(reserve.name, l.Variables[strings.ToLower(reserve.name)])
+		if err != nil {
+			return err
+		}
+		*reserve.value = int(size)
+	}
+	return nil
+}
+
+func parseBool(name, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid value %q for %s", value, name)
+	}
+}
+
+// parseSize parses a size in bytes, or one of the standard disk sizes.
+func parseSize(name, value string) (int64, error) {
+	if size, ok := diskSizes[strings.ToLower(value)]; ok {
+		return size, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid value %q for %s", value, name)
+	}
+	return size, nil
+}
+
+// isAbsolute reports whether a path is absolute on Unix or Windows.
+func isAbsolute(name string) bool {
+	return strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || (len(name) >= 2 && name[1] == ':')
+}
@@ -0,0 +1,156 @@
+package ddf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/secDre4mer/go-cab"
+)
+
+const testDirectives = `; Test directives
+.Option Explicit
+.Define Version=1.2
+.Set CabinetNameTemplate=product*.cab   ; comment
+.Set DiskDirectoryTemplate=out\disk*
+.Set MaxDiskSize=CDROM
+.Set SourceDir=src
+readme.txt
+"file with spaces.txt" "docs\file %Version%.txt"
+.Set CompressionType=LZX
+.Set CompressionMemory=21
+data.bin /inf=no
+.New Folder
+data2.bin /Custom=value
+.Set Cabinet=off
+setup.exe
+.Set DestinationDir=bin
+.Set Cabinet=on
+.Set Compress=off
+tool.exe
+`
+
+func TestParse(t *testing.T) {
+	layout, err := Parse(strings.NewReader(testDirectives), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.CabinetNameTemplate != "product*.cab" || layout.DiskDirectoryTemplate != `out\disk*` ||
+		layout.DiskLabelTemplate != "Disk *" || layout.MaxCabinetSize != 681984000 || !layout.GenerateInf {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	if layout.Variables["version"] != "1.2" {
+		t.Fatal("unexpected user variable", layout.Variables["version"])
+	}
+	expected := []File{
+		{Line: 8, Source: "src/readme.txt", Destination: "readme.txt", Cabinet: true, Compression: cab.CompressionMSZIP, NewFolder: true, Inf: true},
+		{Line: 9, Source: "src/file with spaces.txt", Destination: `docs\file 1.2.txt`, Cabinet: true, Compression: cab.CompressionMSZIP, Inf: true},
+		{Line: 12, Source: "src/data.bin", Destination: "data.bin", Cabinet: true, Compression: cab.CompressionLZX(21), NewFolder: true},
+		{Line: 14, Source: "src/data2.bin", Destination: "data2.bin", Cabinet: true, Compression: cab.CompressionLZX(21), NewFolder: true, Inf: true},
+		{Line: 16, Source: "src/setup.exe", Destination: "setup.exe", Compression: cab.CompressionLZX(21), Inf: true},
+		{Line: 20, Source: "src/tool.exe", Destination: `bin\tool.exe`, Cabinet: true, Compression: cab.CompressionNone, NewFolder: true, Inf: true},
+	}
+	if len(layout.Files) != len(expected) {
+		t.Fatal("unexpected number of files", len(layout.Files))
+	}
+	for i, file := range layout.Files {
+		parameters := file.Parameters
+		file.Parameters = nil
+		if !reflect.DeepEqual(file, expected[i]) {
+			t.Fatalf("file %d: expected %+v, got %+v", i, expected[i], file)
+		}
+		if i == 3 && parameters["custom"] != "value" {
+			t.Fatal("missing parameter", parameters)
+		}
+	}
+}
+
+func TestParseDefines(t *testing.T) {
+	layout, err := Parse(strings.NewReader(".Set Name=%Prefix%-%%\nfile.txt %Name%.txt\n"), map[string]string{
+		"Prefix":      "x",
+		"MaxDiskSize": "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.MaxCabinetSize != 0 || layout.Files[0].Destination != "x-%.txt" {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	layout, err := Parse(strings.NewReader("; 50% done\nfile.txt ; 100%\n\"a;b.txt\" \"c%%;d.txt\" ; %Undefined%\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layout.Files) != 2 || layout.Files[0].Destination != "file.txt" || layout.Files[1].Source != "a;b.txt" ||
+		layout.Files[1].Destination != "c%;d.txt" {
+		t.Fatalf("unexpected files %+v", layout.Files)
+	}
+}
+
+func TestParseMaxCabinetSize(t *testing.T) {
+	for directives, expected := range map[string]int64{
+		".Set MaxCabinetSize=1000":                          1000,
+		".Set MaxCabinetSize=2000000":                       1457664,
+		".Set MaxDiskSize=0":                                0,
+		".Set MaxDiskSize=720K\n.Set MaxCabinetSize=100000": 100000,
+	} {
+		layout, err := Parse(strings.NewReader(directives), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layout.MaxCabinetSize != expected {
+			t.Fatal(directives, "unexpected maximum cabinet size", layout.MaxCabinetSize)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, directives := range []string{
+		".Option Explicit\n.Set Undefined=1",
+		".Define CompressionType=LZX",
+		".Set File=%Undefined%",
+		".Set Name=%Unterminated",
+		`file.txt "unterminated`,
+		".Set Value=two words",
+		".Set NoValue",
+		".Set ClusterSize=512",
+		".Set ReservePerFolderSize=huge",
+		".Set Compress=maybe\nfile.txt",
+		".Set CompressionType=Quantum\nfile.txt",
+		".Set CompressionType=LZX\n.Set CompressionMemory=22\nfile.txt",
+		".Set MaxDiskSize=large",
+		"file.txt\nother\\file.txt",
+		"file.txt /inf",
+		"file.txt /inf=maybe",
+		"file.txt\n.Set CabinetNameTemplate=other*.cab",
+		".New Cabinet",
+		".Option Unknown",
+		".Dump",
+	} {
+		if _, err := Parse(strings.NewReader(directives), nil); err == nil {
+			t.Fatal("expected error for", directives)
+		}
+	}
+}
+
+func TestParseUniqueFiles(t *testing.T) {
+	layout, err := Parse(strings.NewReader(".Set UniqueFiles=off\na\\file.txt\nb\\FILE.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layout.Files) != 2 {
+		t.Fatal("unexpected number of files", len(layout.Files))
+	}
+}
+
+func TestParseReserve(t *testing.T) {
+	layout, err := Parse(strings.NewReader(".Set ReservePerCabinetSize=6144\n.Set ReservePerDataBlockSize=8\nfile.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Reserve != (cab.Reserve{Header: 6144, Data: 8}) {
+		t.Fatal("unexpected reserve", layout.Reserve)
+	}
+}
@@ -0,0 +1,160 @@
+package cab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrInvalidSignature means that the data does not start with a cabinet signature.
+	ErrInvalidSignature = errors.New("CAB signature did not match")
+	// ErrUnsupportedVersion means that the cabinet format version is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported cabinet version")
+	// ErrTruncated means that the cabinet ends before a structure or a data block that it references.
+	ErrTruncated = errors.New("cabinet is truncated")
+	// ErrInvalidFolderReference means that a CFFILE entry references a folder that does not exist.
+	ErrInvalidFolderReference = errors.New("invalid folder reference")
+	// ErrInvalidName means that a file name with AttributeNameUtf set is not valid UTF-8.
+	ErrInvalidName = errors.New("file name is not valid UTF-8")
+	// ErrInvalidLayout means that the structures of the cabinet are arranged in a way that is not supported.
+	ErrInvalidLayout = errors.New("invalid cabinet layout")
+	// ErrChecksumMismatch means that the checksum of a CFDATA block does not match its contents.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrUnsupportedCompression means that a folder uses an unknown compression type.
+	ErrUnsupportedCompression = errors.New("unsupported compression type")
+	// ErrCorruptData means that the compressed data of a folder could not be decompressed.
+	ErrCorruptData = errors.New("corrupt compressed data")
+	// ErrMissingVolume means that data continues in a cabinet of a multi-cabinet set that was not opened.
+	ErrMissingVolume = errors.New("data continues in another cabinet of the set")
+	// ErrInvalidSet means that cabinets do not form a valid multi-cabinet set.
+	ErrInvalidSet = errors.New("invalid multi-cabinet set")
+	// ErrLimitExceeded means that a cabinet exceeds one of the configured Limits. The errors for the individual
+	// limits wrap it.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrTooManyFiles means that a cabinet has more files than Limits.MaxFiles.
+	ErrTooManyFiles = fmt.Errorf("%w: too many files", ErrLimitExceeded)
+	// ErrTooManyFolders means that a cabinet has more folders than Limits.MaxFolders.
+	ErrTooManyFolders = fmt.Errorf("%w: too many folders", ErrLimitExceeded)
+	// ErrTooManyDataBlocks means that a cabinet has more data blocks than Limits.MaxDataBlocks.
+	ErrTooManyDataBlocks = fmt.Errorf("%w: too many data blocks", ErrLimitExceeded)
+	// ErrUncompressedSizeLimit means that the declared uncompressed size exceeds Limits.MaxUncompressedSize.
+	ErrUncompressedSizeLimit = fmt.Errorf("%w: uncompressed size too large", ErrLimitExceeded)
+	// ErrCompressionRatioLimit means that the compression ratio of a folder exceeds Limits.MaxCompressionRatio.
+	ErrCompressionRatioLimit = fmt.Errorf("%w: compression ratio too high", ErrLimitExceeded)
+	// ErrWindowSizeLimit means that a folder uses a larger window than Limits.MaxWindowSize.
+	ErrWindowSizeLimit = fmt.Errorf("%w: compression window too large", ErrLimitExceeded)
+	// ErrNameTooLong means that a name is longer than Limits.MaxNameLength.
+	ErrNameTooLong = fmt.Errorf("%w: name too long", ErrLimitExceeded)
+	// ErrUnsafePath means that Cabinet.ExtractTo refused a file name or a symbolic link in the target directory.
+	ErrUnsafePath = errors.New("unsafe path")
+	// ErrReservedHeaderSize means that PatchReservedHeader cannot store data in a cabinet because it has no reserved
+	// header area of the same size. AddReservedHeader writes a copy of the cabinet with a suitable area.
+	ErrReservedHeaderSize = errors.New("no reserved header area of matching size")
+	// ErrStreamedFile means that a file from a StreamReader was opened directly.
+	ErrStreamedFile = errors.New("file can only be read through its StreamReader")
+)
+
+// Structure identifies a structure of the cabinet format.
+type Structure int
+
+const (
+	StructureHeader Structure = iota + 1 // CFHEADER
+	StructureFolder                      // CFFOLDER
+	StructureFile                        // CFFILE
+	StructureData                        // CFDATA
+)
+
+func (s Structure) String() string {
+	switch s {
+	case StructureHeader:
+		return "CFHEADER"
+	case StructureFolder:
+		return "CFFOLDER"
+	case StructureFile:
+		return "CFFILE"
+	case StructureData:
+		return "CFDATA"
+	default:
+		return "unknown structure"
+	}
+}
+
+// FormatError describes a problem with a structure of a cabinet. Err is usually one of the sentinel errors of this
+// package, possibly wrapping the underlying error; use errors.Is to check for them.
+type FormatError struct {
+	Structure Structure
+	Folder    int   // Index of the folder, or -1 if not applicable
+	Block     int   // Index of the data block in the folder, or -1 if not applicable
+	File      int   // Index of the CFFILE entry, or -1 if not applicable
+	Offset    int64 // Offset of the structure in the cabinet, or -1 if unknown
+	// Volume is the index of the cabinet in its multi-cabinet set (iSet) for data blocks of a set that was opened with
+	// OpenSet or OpenFS, or -1 if not applicable. Folder and Block are indices within that cabinet.
+	Volume int
+	// VolumeName is the name of that cabinet in the file system if the set was opened with OpenFS.
+	VolumeName string
+	Err        error
+}
+
+func (e *FormatError) Error() string {
+	var description strings.Builder
+	description.WriteString(e.Structure.String())
+	if e.Folder >= 0 {
+		fmt.Fprintf(&description, " folder %d", e.Folder)
+	}
+	if e.Block >= 0 {
+		fmt.Fprintf(&description, " block %d", e.Block)
+	}
+	if e.File >= 0 {
+		fmt.Fprintf(&description, " file %d", e.File)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&description, " at offset %d", e.Offset)
+	}
+	if e.Volume >= 0 {
+		fmt.Fprintf(&description, " in cabinet %d", e.Volume)
+		if e.VolumeName != "" {
+			fmt.Fprintf(&description, " (%s)", e.VolumeName)
+		}
+	}
+	return description.String() + ": " + e.Err.Error()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+func headerError(offset int64, err error) error {
+	return &FormatError{Structure: StructureHeader, Folder: -1, Block: -1, File: -1, Offset: offset, Volume: -1, Err: truncated(err)}
+}
+
+func folderError(folder int, offset int64, err error) error {
+	return &FormatError{Structure: StructureFolder, Folder: folder, Block: -1, File: -1, Offset: offset, Volume: -1, Err: truncated(err)}
+}
+
+func fileError(file int, offset int64, err error) error {
+	return &FormatError{Structure: StructureFile, Folder: -1, Block: -1, File: file, Offset: offset, Volume: -1, Err: truncated(err)}
+}
+
+func dataError(folder, block int, offset int64, err error) error {
+	return &FormatError{Structure: StructureData, Folder: folder, Block: block, File: -1, Offset: offset, Volume: -1, Err: truncated(err)}
+}
+
+// truncated converts errors for reads beyond the end of the input to ErrTruncated.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// corruptData wraps errors of a decompressor that are not already described by a FormatError.
+func corruptData(folder int, err error) error {
+	var formatErr *FormatError
+	if err == nil || err == io.EOF || errors.As(err, &formatErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &FormatError{Structure: StructureData, Folder: folder, Block: -1, File: -1, Offset: -1, Volume: -1, Err: fmt.Errorf("%w: %w", ErrCorruptData, err)}
+}
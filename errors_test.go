@@ -0,0 +1,156 @@
+package cab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func expectFormatError(t *testing.T, err error, target error, structure Structure) *FormatError {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("expected %v, got %v", target, err)
+	}
+	var formatErr *FormatError
+	if !errors.As(err, &formatErr) {
+		t.Fatal("expected FormatError, got", err)
+	}
+	if formatErr.Structure != structure {
+		t.Fatal("unexpected structure", formatErr.Structure)
+	}
+	return formatErr
+}
+
+func TestErrorInvalidSignature(t *testing.T) {
+	data := []byte("MSCE" + string(make([]byte, 60)))
+	_, err := Open(bytes.NewReader(data), int64(len(data)))
+	expectFormatError(t, err, ErrInvalidSignature, StructureHeader)
+}
+
+func TestErrorTruncated(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(bytes.NewReader(testfileData[:20]), 20)
+	expectFormatError(t, err, ErrTruncated, StructureHeader)
+
+	// Cut off within the first CFFOLDER entry
+	_, err = Open(bytes.NewReader(testfileData), 40)
+	formatErr := expectFormatError(t, err, ErrTruncated, StructureFolder)
+	if formatErr.Folder != 0 || formatErr.Offset != 36 {
+		t.Fatal("unexpected folder index or offset", formatErr)
+	}
+}
+
+func TestErrorChecksumMismatch(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataOffset := binary.LittleEndian.Uint32(testfileData[36:])
+	testfileData[dataOffset] ^= 0xFF
+	cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(reader)
+	formatErr := expectFormatError(t, err, ErrChecksumMismatch, StructureData)
+	if formatErr.Folder != 0 || formatErr.Block != 0 || formatErr.Offset != int64(dataOffset) || formatErr.Volume != -1 {
+		t.Fatal("unexpected position", formatErr)
+	}
+}
+
+func TestErrorChecksumMismatchFileTail(t *testing.T) {
+	content := testSetContent()
+	volume := testVolume{
+		blocks: []testBlock{{content[:1000], 1000}, {content[1000:2000], 1000}, {content[2000:3000], 1000}},
+		files:  []testFile{{"first.bin", 0, 1500, 0}, {"second.bin", 1500, 500, 0}},
+	}
+	cabData := buildTestVolume(volume)
+	// Corrupt the second block behind the end of the first file
+	dataOffset := len(cabData) - 2*(8+1000)
+	cabData[dataOffset+8+900] ^= 0xFF
+	cabFile, err := Open(bytes.NewReader(cabData), int64(len(cabData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mismatch is reported at the end of the file, although the end of the block was not needed
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	formatErr := expectFormatError(t, err, ErrChecksumMismatch, StructureData)
+	if formatErr.Block != 1 || len(data) != 1500 {
+		t.Fatal("unexpected block or data length", formatErr.Block, len(data))
+	}
+	if err := reader.Close(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatal("expected Close to return the mismatch", err)
+	}
+
+	// Closing verifies the partially read block
+	reader, err = cabFile.Files[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	expectFormatError(t, reader.Close(), ErrChecksumMismatch, StructureData)
+
+	// Blocks that do not overlap the file are not verified
+	cabData = buildTestVolume(volume)
+	cabData[len(cabData)-1] ^= 0xFF
+	cabFile, err = Open(bytes.NewReader(cabData), int64(len(cabData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err = cabFile.Files[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrorUnsupportedCompression(t *testing.T) {
+	content := testSetContent()
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1000], 1000}},
+		files:  []testFile{{"file.bin", 0, 1000, 0}},
+	})
+	// Set the compression type of the folder
+	binary.LittleEndian.PutUint16(cabData[36+6:], 0xF)
+	cabFile, err := Open(bytes.NewReader(cabData), int64(len(cabData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cabFile.Files[0].Open()
+	expectFormatError(t, err, ErrUnsupportedCompression, StructureFolder)
+}
+
+func TestErrorInvalidFolderReference(t *testing.T) {
+	content := testSetContent()
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1000], 1000}},
+		files:  []testFile{{"file.bin", 0, 1000, 0}, {"invalid.bin", 0, 1000, 1}},
+	})
+	_, err := Open(bytes.NewReader(cabData), int64(len(cabData)))
+	formatErr := expectFormatError(t, err, ErrInvalidFolderReference, StructureFile)
+	if formatErr.File != 1 {
+		t.Fatal("unexpected file index", formatErr.File)
+	}
+}
@@ -0,0 +1,254 @@
+package cab
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ExtractOptions configures Cabinet.ExtractTo. A nil *ExtractOptions is equivalent to the zero value.
+type ExtractOptions struct {
+	// RejectUnsafeNames makes ExtractTo fail with ErrUnsafePath before anything is written if a file name would have
+	// to be rewritten, instead of rewriting it.
+	RejectUnsafeNames bool
+	// Overwrite allows replacing regular files that already exist in the target directory.
+	Overwrite bool
+	// Workers is the number of goroutines that extract files, see ExtractParallel. If it is less than 2, files are
+	// extracted sequentially with Walk.
+	Workers int
+	// DOSAttributes stores the read-only, hidden, system and archive attributes of each file in the user.DOSATTRIB
+	// extended attribute, in the format that Samba uses. This is only supported on Linux.
+	DOSAttributes bool
+}
+
+// Rename describes a file whose name was rewritten by ExtractTo.
+type Rename struct {
+	File    *File
+	Path    string   // Slash-separated path of the extracted file, relative to the target directory
+	Reasons []string // Why the name was rewritten
+}
+
+// ExtractTo extracts all files of the cabinet into dir, which must exist. File names are converted to paths within
+// dir: backslashes are treated as path separators, and absolute paths, drive letters, "." and ".." components, NUL
+// bytes and Windows device names such as CON or COM1 are rewritten (or rejected, see
+// ExtractOptions.RejectUnsafeNames). If a file would be extracted to the same path as a preceding file, "~1", "~2" and
+// so on is inserted before its extension; with RejectUnsafeNames, ExtractTo fails with ErrUnsafePath instead.
+// ExtractTo returns every rename it made, in the order of Files.
+//
+// Missing directories below dir are created. ExtractTo fails with ErrUnsafePath instead of following a symbolic link
+// below dir, and it does not replace existing files unless ExtractOptions.Overwrite is set.
+//
+// The modification time of each extracted file is set to File.Modified, unless the timestamp is invalid. Files with
+// AttributeReadOnly are created without write permissions, and files with AttributeExec with execute permissions; see
+// Attributes.Mode.
+func (cab *Cabinet) ExtractTo(dir string, opts *ExtractOptions) ([]Rename, error) {
+	var options ExtractOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.DOSAttributes && !xattrSupported {
+		return nil, errors.New("extended attributes are not supported on this platform")
+	}
+	paths := map[*File]string{}
+	owners := map[string]*File{} // File that is extracted to each path
+	var renames []Rename
+	for _, file := range cab.Files {
+		filePath, reasons := sanitizePath(file.Name)
+		if len(reasons) > 0 && options.RejectUnsafeNames {
+			return nil, fmt.Errorf("%w: %q: %s", ErrUnsafePath, file.Name, strings.Join(reasons, ", "))
+		}
+		if owner := owners[filePath]; owner != nil {
+			if options.RejectUnsafeNames {
+				return nil, fmt.Errorf("%w: %q and %q are both extracted to %s", ErrUnsafePath, owner.Name, file.Name, filePath)
+			}
+			filePath = uniquePath(filePath, owners)
+			reasons = append(reasons, fmt.Sprintf("same path as %q", owner.Name))
+		}
+		if len(reasons) > 0 {
+			renames = append(renames, Rename{File: file, Path: filePath, Reasons: reasons})
+		}
+		paths[file] = filePath
+		owners[filePath] = file
+	}
+
+	target := extractTarget{dir: dir, overwrite: options.Overwrite, dosAttributes: options.DOSAttributes, dirs: map[string]bool{}}
+	sink := func(file *File, reader io.Reader) error {
+		return target.write(file, paths[file], reader)
+	}
+	var err error
+	if options.Workers > 1 {
+		err = cab.ExtractParallel(options.Workers, sink)
+	} else {
+		err = cab.Walk(sink)
+	}
+	return renames, err
+}
+
+// sanitizePath converts a file name to a slash-separated relative path without unsafe components. It returns the
+// reasons for every change that is not just a conversion of backslashes.
+func sanitizePath(name string) (string, []string) {
+	var reasons []string
+	if strings.ContainsRune(name, 0) {
+		name = strings.ReplaceAll(name, "\x00", "_")
+		reasons = append(reasons, "NUL byte")
+	}
+	name = strings.ReplaceAll(name, `\`, "/")
+	if len(name) >= 2 && name[1] == ':' && ('a' <= name[0]|0x20 && name[0]|0x20 <= 'z') {
+		name = name[2:]
+		reasons = append(reasons, "drive letter")
+	}
+	if strings.HasPrefix(name, "/") {
+		reasons = append(reasons, "absolute path")
+	}
+	var components []string
+	var dotComponents, deviceNames bool
+	for _, component := range strings.Split(name, "/") {
+		switch component {
+		case "":
+			continue
+		case ".", "..":
+			dotComponents = true
+			continue
+		}
+		if isDeviceName(component) {
+			component = "_" + component
+			deviceNames = true
+		}
+		components = append(components, component)
+	}
+	if dotComponents {
+		reasons = append(reasons, "relative path component")
+	}
+	if deviceNames {
+		reasons = append(reasons, "device name")
+	}
+	if len(components) == 0 {
+		components = []string{"_"}
+		reasons = append(reasons, "empty name")
+	}
+	return strings.Join(components, "/"), reasons
+}
+
+// uniquePath inserts "~1", "~2" and so on before the extension of a slash-separated path until it is not used yet.
+func uniquePath(filePath string, used map[string]*File) string {
+	extension := path.Ext(filePath)
+	if extension == path.Base(filePath) {
+		// Names like ".profile" have no extension
+		extension = ""
+	}
+	base := strings.TrimSuffix(filePath, extension)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s~%d%s", base, i, extension)
+		if used[candidate] == nil {
+			return candidate
+		}
+	}
+}
+
+// isDeviceName reports whether a path component refers to a Windows device, regardless of its extension.
+func isDeviceName(component string) bool {
+	base, _, _ := strings.Cut(component, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// extractTarget writes files into a directory without following symbolic links below it.
+type extractTarget struct {
+	dir           string
+	overwrite     bool
+	dosAttributes bool
+
+	mutex sync.Mutex
+	dirs  map[string]bool // Directories below dir that were checked or created
+}
+
+// write writes a file to the slash-separated path below the target directory and applies its metadata.
+func (t *extractTarget) write(cabFile *File, filePath string, reader io.Reader) error {
+	fullPath := filepath.Join(t.dir, filepath.FromSlash(filePath))
+	if parent := path.Dir(filePath); parent != "." {
+		if err := t.makeDirs(parent); err != nil {
+			return err
+		}
+	}
+	if t.overwrite {
+		info, err := os.Lstat(fullPath)
+		if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symbolic link", ErrUnsafePath, fullPath)
+		}
+		// Remove the file instead of truncating it, since it may be read-only
+		if err == nil && !info.IsDir() {
+			if err := os.Remove(fullPath); err != nil {
+				return err
+			}
+		}
+	}
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.dosAttributes {
+		if err := setDOSAttributes(fullPath, cabFile.Attributes); err != nil {
+			return err
+		}
+	}
+	if !cabFile.InvalidTimestamp {
+		if err := os.Chtimes(fullPath, cabFile.Modified, cabFile.Modified); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(fullPath, cabFile.Attributes.Mode())
+}
+
+// makeDirs creates the slash-separated directory path below the target directory, failing if one of its
+// components is a symbolic link.
+func (t *extractTarget) makeDirs(dirPath string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	components := strings.Split(dirPath, "/")
+	for i := range components {
+		current := strings.Join(components[:i+1], "/")
+		if t.dirs[current] {
+			continue
+		}
+		fullPath := filepath.Join(t.dir, filepath.FromSlash(current))
+		info, err := os.Lstat(fullPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := os.Mkdir(fullPath, 0o755); err != nil {
+				return err
+			}
+			info, err = os.Lstat(fullPath)
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symbolic link", ErrUnsafePath, fullPath)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", fullPath)
+		}
+		t.dirs[current] = true
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package cab
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSanitizePath(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		path    string
+		reasons string
+	}{
+		{`dir\file.txt`, "dir/file.txt", ""},
+		{`..\..\etc\cron.d\x`, "etc/cron.d/x", "relative path component"},
+		{`C:\Windows\system.ini`, "Windows/system.ini", "drive letter, absolute path"},
+		{`\\server\share\file`, "server/share/file", "absolute path"},
+		{"/etc/passwd", "etc/passwd", "absolute path"},
+		{"a/./b", "a/b", "relative path component"},
+		{"file\x00.txt", "file_.txt", "NUL byte"},
+		{`dir\CON`, "dir/_CON", "device name"},
+		{"com1.txt", "_com1.txt", "device name"},
+		{"lpt9", "_lpt9", "device name"},
+		{"COM0", "COM0", ""},
+		{"console", "console", ""},
+		{"..", "_", "relative path component, empty name"},
+	} {
+		path, reasons := sanitizePath(test.name)
+		if path != test.path || strings.Join(reasons, ", ") != test.reasons {
+			t.Errorf("%q: got %q (%s), expected %q (%s)", test.name, path, strings.Join(reasons, ", "), test.path, test.reasons)
+		}
+	}
+}
+
+func buildExtractTestCabinet(t *testing.T, names ...string) (*Cabinet, []byte) {
+	t.Helper()
+	content := testSetContent()
+	var files []testFile
+	for i, name := range names {
+		files = append(files, testFile{name, uint32(i * 100), 100, 0})
+	}
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1000], 1000}},
+		files:  files,
+	})
+	cabFile, err := Open(bytes.NewReader(cabData), int64(len(cabData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cabFile, content
+}
+
+func TestExtractTo(t *testing.T) {
+	cabFile, content := buildExtractTestCabinet(t, `dir\file.txt`, `..\..\evil.txt`, `C:\abs\file.txt`, `dir\sub\NUL.txt`)
+	dir := t.TempDir()
+	renames, err := cabFile.ExtractTo(dir, &ExtractOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renames) != 3 || renames[0].File != cabFile.Files[1] || renames[0].Path != "evil.txt" {
+		t.Fatal("unexpected renames", renames)
+	}
+	for i, expectedPath := range []string{"dir/file.txt", "evil.txt", "abs/file.txt", "dir/sub/_NUL.txt"} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(expectedPath)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content[i*100:(i+1)*100]) {
+			t.Fatal("content mismatch for", expectedPath)
+		}
+	}
+
+	// Existing files are only replaced with Overwrite
+	if _, err := cabFile.ExtractTo(dir, nil); !errors.Is(err, os.ErrExist) {
+		t.Fatal("expected error for existing file, got", err)
+	}
+	if _, err := cabFile.ExtractTo(dir, &ExtractOptions{Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cabFile.ExtractTo(t.TempDir(), &ExtractOptions{RejectUnsafeNames: true}); !errors.Is(err, ErrUnsafePath) {
+		t.Fatal("expected unsafe name to be rejected, got", err)
+	}
+}
+
+func TestExtractToCollisions(t *testing.T) {
+	cabFile, content := buildExtractTestCabinet(t, `dir\file.txt`, `..\dir\file.txt`, "dir/file.txt", `\.profile`, ".profile")
+	dir := t.TempDir()
+	renames, err := cabFile.ExtractTo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var renamed []string
+	for _, rename := range renames {
+		renamed = append(renamed, rename.Path+": "+strings.Join(rename.Reasons, ", "))
+	}
+	expectedRenames := []string{
+		`dir/file~1.txt: relative path component, same path as "dir\\file.txt"`,
+		`dir/file~2.txt: same path as "dir\\file.txt"`,
+		`.profile: absolute path`,
+		`.profile~1: same path as "\\.profile"`,
+	}
+	if strings.Join(renamed, "\n") != strings.Join(expectedRenames, "\n") {
+		t.Fatal("unexpected renames", renamed)
+	}
+	for i, expectedPath := range []string{"dir/file.txt", "dir/file~1.txt", "dir/file~2.txt", ".profile", ".profile~1"} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(expectedPath)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content[i*100:(i+1)*100]) {
+			t.Fatal("content mismatch for", expectedPath)
+		}
+	}
+
+	cabFile, _ = buildExtractTestCabinet(t, `dir\file.txt`, "dir/file.txt")
+	_, err = cabFile.ExtractTo(t.TempDir(), &ExtractOptions{RejectUnsafeNames: true})
+	if !errors.Is(err, ErrUnsafePath) || !strings.Contains(err.Error(), `"dir\\file.txt" and "dir/file.txt"`) {
+		t.Fatal("expected collision to be rejected, got", err)
+	}
+}
+
+func TestExtractToSymlink(t *testing.T) {
+	cabFile, _ := buildExtractTestCabinet(t, `link\file.txt`, `target.txt`)
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	if _, err := cabFile.ExtractTo(dir, nil); !errors.Is(err, ErrUnsafePath) {
+		t.Fatal("expected symbolic link to be refused, got", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("file was written through symbolic link")
+	}
+
+	// Symbolic links to files are not replaced or followed either
+	dir = t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "target.txt"), filepath.Join(dir, "target.txt")); err != nil {
+		t.Fatal(err)
+	}
+	cabFile, _ = buildExtractTestCabinet(t, `target.txt`)
+	for _, opts := range []*ExtractOptions{nil, {Overwrite: true}} {
+		if _, err := cabFile.ExtractTo(dir, opts); err == nil {
+			t.Fatal("expected symbolic link to be refused")
+		}
+		if _, err := os.Stat(filepath.Join(outside, "target.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Fatal("file was written through symbolic link")
+		}
+	}
+}
+
+func TestExtractToMetadata(t *testing.T) {
+	cabFile, _ := buildExtractTestCabinet(t, "plain.txt", "readonly.txt", "exec.sh")
+	cabFile.Files[1].Attributes |= AttributeReadOnly
+	cabFile.Files[2].Attributes |= AttributeExec
+	dir := t.TempDir()
+	if _, err := cabFile.ExtractTo(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range cabFile.Files {
+		info, err := os.Stat(filepath.Join(dir, file.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(file.Modified) {
+			t.Fatal("unexpected modification time", file.Name, info.ModTime())
+		}
+		if runtime.GOOS == "windows" {
+			continue
+		}
+		perm := info.Mode().Perm()
+		if (perm&0o200 == 0) != (file.Attributes&AttributeReadOnly != 0) {
+			t.Fatal("unexpected write permission", file.Name, perm)
+		}
+		if (perm&0o100 != 0) != (file.Attributes&AttributeExec != 0) {
+			t.Fatal("unexpected execute permission", file.Name, perm)
+		}
+	}
+}
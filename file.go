@@ -1,6 +1,8 @@
 package cab
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"io/fs"
 	"path"
@@ -8,32 +10,149 @@ import (
 )
 
 type File struct {
-	Name       string
-	Modified   time.Time
-	Attributes uint16
+	// Name is the decoded name of the file, see Options.NameDecoder.
+	Name string
+	// RawName is the name as stored in the cabinet.
+	RawName []byte
+	// Modified is the modification time in Options.Location. It is the zero time if InvalidTimestamp is set.
+	Modified time.Time
+	// DOSDate and DOSTime are the date and time as stored in the cabinet, in the format of the MS-DOS file system.
+	DOSDate, DOSTime uint16
+	// InvalidTimestamp is set if a field of DOSDate or DOSTime is out of range, e.g. month 0 or February 31.
+	InvalidTimestamp bool
+	Attributes       Attributes
 
 	header cabinetFileEntryHeader
 	folder *cabinetFileFolder
 }
 
+// Attributes is the set of attribute flags of a file.
+type Attributes uint16
+
 const (
-	AttributeReadOnly = 0x1
-	AttributeHidden   = 0x2
-	AttributeSystem   = 0x4
-	AttributeArch     = 0x20
-	AttributeExec     = 0x40
-	AttributeNameUtf  = 0x80
+	AttributeReadOnly Attributes = 0x1
+	AttributeHidden   Attributes = 0x2
+	AttributeSystem   Attributes = 0x4
+	AttributeArch     Attributes = 0x20
+	AttributeExec     Attributes = 0x40
+	AttributeNameUtf  Attributes = 0x80
+
+	// dosAttributeMask contains the attributes that have the same meaning in DOS; the others are specific to
+	// cabinets.
+	dosAttributeMask = AttributeReadOnly | AttributeHidden | AttributeSystem | AttributeArch
 )
 
-func (f *File) Open() (io.Reader, error) {
-	folderReader, err := f.folder.open()
+// attributeLetters contains the letter and bit of each of the lowest eight attributes, in the order of String. 0x8
+// and 0x10 are the DOS volume label and directory attributes, which cabinets do not use.
+var attributeLetters = [8]struct {
+	letter byte
+	bit    Attributes
+}{{'R', 0x1}, {'H', 0x2}, {'S', 0x4}, {'A', 0x20}, {'D', 0x10}, {'X', 0x40}, {'V', 0x8}, {'U', 0x80}}
+
+// String returns one character per attribute: R (read-only), H (hidden), S (system), A (archive), D (directory),
+// X (exec), V (volume label) and U (UTF-8 name), or "-" if the attribute is not set, e.g. "RHSA-X-U". Higher bits are
+// appended in hexadecimal if they are set, e.g. "---A----+0x100".
+func (a Attributes) String() string {
+	description := []byte("--------")
+	for i, attribute := range attributeLetters {
+		if a&attribute.bit != 0 {
+			description[i] = attribute.letter
+		}
+	}
+	if unknown := a &^ 0xFF; unknown != 0 {
+		return fmt.Sprintf("%s+%#x", description, uint16(unknown))
+	}
+	return string(description)
+}
+
+// Mode returns the permissions for a file with these attributes: 0644, without write permissions for read-only
+// files and with execute permissions for files with AttributeExec. Hidden and system files have no equivalent in
+// fs.FileMode.
+func (a Attributes) Mode() fs.FileMode {
+	var perm fs.FileMode = 0o644
+	if a&AttributeReadOnly != 0 {
+		perm &^= 0o222
+	}
+	if a&AttributeExec != 0 {
+		perm |= 0o111
+	}
+	return perm
+}
+
+// Open opens the file for reading. The checksums of all data blocks that overlap the file are verified once the end
+// of the file is reached or the returned reader is closed, whichever comes first; a mismatch is returned by Read
+// instead of io.EOF, or by Close. The returned reader must be closed to release the decompressor.
+func (f *File) Open() (io.ReadCloser, error) {
+	return f.OpenContext(context.Background())
+}
+
+// OpenContext opens the file for reading like Open. Once ctx is done, reading fails with ctx.Err(); this is checked
+// before every read from a data block, including while the data preceding the file in its folder is skipped.
+func (f *File) OpenContext(ctx context.Context) (io.ReadCloser, error) {
+	folderReader, err := f.folder.openAt(ctx, 0, f.endBlock(), nil)
 	if err != nil {
 		return nil, err
 	}
 	if _, err := io.CopyN(io.Discard, folderReader, int64(f.header.UncompressedOffsetInFolder)); err != nil {
+		folderReader.Close()
 		return nil, err
 	}
-	return io.LimitReader(folderReader, int64(f.header.UncompressedFileSize)), nil
+	return &fileReader{folderReader: folderReader, remaining: int64(f.header.UncompressedFileSize)}, nil
+}
+
+// endBlock returns the index of the first data block in the folder after the data of the file.
+func (f *File) endBlock() int {
+	end := int64(f.header.UncompressedOffsetInFolder) + int64(f.header.UncompressedFileSize)
+	var blockEnd int64
+	for i := range f.folder.dataEntries {
+		if blockEnd >= end {
+			return i
+		}
+		blockEnd += int64(f.folder.dataEntries[i].uncompressedSize())
+	}
+	return len(f.folder.dataEntries)
+}
+
+// fileReader reads the data of a file from the reader of its folder. The folder reader is closed at the end of the
+// file, which verifies the checksums of the data blocks that were only read partially.
+type fileReader struct {
+	folderReader io.ReadCloser
+	remaining    int64
+	closed       bool
+	closeErr     error
+}
+
+func (r *fileReader) Read(b []byte) (int, error) {
+	if r.remaining == 0 {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if r.closed {
+		return 0, fs.ErrClosed
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+	n, err := r.folderReader.Read(b)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		// The folder contains less data than the file needs
+		err = io.ErrUnexpectedEOF
+	}
+	if r.remaining == 0 && (err == nil || err == io.EOF) {
+		err = r.Close()
+	}
+	return n, err
+}
+
+func (r *fileReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.closeErr = r.folderReader.Close()
+	}
+	return r.closeErr
 }
 
 func (f *File) Stat() fs.FileInfo {
@@ -45,17 +164,19 @@ type FileInfo struct {
 }
 
 func (f FileInfo) Name() string {
-	return path.Base(f.File.Name)
+	return path.Base(fsPath(f.File.Name))
 }
 
 func (f FileInfo) Size() int64 {
 	return int64(f.File.header.UncompressedFileSize)
 }
 
+// Mode returns the mode of a regular file with the permissions derived from the attributes, see Attributes.Mode.
 func (f FileInfo) Mode() fs.FileMode {
-	return 0
+	return f.File.Attributes.Mode()
 }
 
+// ModTime returns File.Modified, which is the zero time if the timestamp of the file is invalid.
 func (f FileInfo) ModTime() time.Time {
 	return f.File.Modified
 }
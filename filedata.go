@@ -2,30 +2,68 @@ package cab
 
 import (
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 )
 
-// openFileData returns an io.ReadCloser that verifies the checksum of the entry, if it exists.
-func openFileData(entry *cabinetFileData) (io.ReadCloser, error) {
-	if entry.UncompressedBytes == 0 {
-		// continued entry, see https://docs.microsoft.com/en-us/previous-versions//bb267310(v=vs.85)#cfdata
-		return nil, errors.New("continued entries are not supported")
-	}
+// openFileData returns an io.ReadCloser for the data of the entry. Depending on the checksum policy, it verifies the
+// checksum of the entry, if it exists. If the entry was split across cabinets, the returned reader covers all parts
+// of the entry.
+func openFileData(entry *cabinetFileData, options *Options) (io.ReadCloser, error) {
 	// Open a separate section reader for this file data reader to prevent race conditions on the underlying section reader
 	reader := io.NewSectionReader(entry.compressedData, 0, entry.compressedData.Size())
-	return &dataEntryReader{entry, reader, checksumWriter{}}, nil
+	entryReader := &dataEntryReader{
+		entry:    entry,
+		reader:   reader,
+		verify:   options.Checksums != ChecksumIgnore && !options.verified.contains(entry),
+		report:   options.report,
+		verified: options.verified,
+	}
+	if entry.UncompressedBytes != 0 {
+		return entryReader, nil
+	}
+	// continued entry, see https://docs.microsoft.com/en-us/previous-versions//bb267310(v=vs.85)#cfdata
+	if entry.next == nil {
+		// Fail only once the missing part is actually needed
+		return &multiReader{Readers: []io.ReadCloser{entryReader, failingReader{entry.error(ErrMissingVolume)}}}, nil
+	}
+	continuation, err := openFileData(entry.next, options)
+	if err != nil {
+		return nil, err
+	}
+	return &multiReader{Readers: []io.ReadCloser{entryReader, continuation}}, nil
+}
+
+type failingReader struct {
+	err error
+}
+
+func (f failingReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+func (f failingReader) Close() error {
+	return nil
 }
 
 type dataEntryReader struct {
 	entry    *cabinetFileData
 	reader   io.Reader
+	read     int
+	verify   bool
+	report   *checksumReport // Set for ChecksumReport
+	verified *verifiedBlocks // Set for Options.CacheVerifiedBlocks
 	checksum checksumWriter
 }
 
 func (d *dataEntryReader) Read(data []byte) (n int, err error) {
 	n, err = d.reader.Read(data)
-	if d.entry.Checksum != 0 {
+	d.read += n
+	if err == io.EOF && d.read < int(d.entry.CompressedBytes) {
+		// The cabinet ends within the data block
+		return n, d.entry.error(ErrTruncated)
+	}
+	if d.verify && d.entry.Checksum != 0 {
 		// Write data for later checksum check
 		d.checksum.Write(data[:n])
 	}
@@ -33,14 +71,14 @@ func (d *dataEntryReader) Read(data []byte) (n int, err error) {
 }
 
 func (d *dataEntryReader) Close() (err error) {
-	if d.entry.Checksum == 0 {
+	if !d.verify || d.entry.Checksum == 0 {
 		return nil // No checksum set for this entry
 	}
-	if d.checksum.Checksum == 0 { // No data read yet - no reason to verify checksum
+	if d.read == 0 { // No data read yet - no reason to verify checksum
 		return nil
 	}
 	// Copy remaining data from underlying reader to ensure we can verify the checksum
-	_, err = io.Copy(&d.checksum, d.reader)
+	_, err = io.Copy(io.Discard, d)
 	if err != nil {
 		return err
 	}
@@ -52,13 +90,15 @@ func (d *dataEntryReader) Close() (err error) {
 	})
 	d.checksum.Write(d.entry.reservedData)
 	d.checksum.Flush()
-	if d.checksum.Checksum != d.entry.Checksum && d.entry.Checksum != 0 {
-		return errors.New("checksum mismatch")
-	} else {
-		// Set checksum on entry to 0 to avoid checking it again later
-		d.entry.Checksum = 0
+	if d.checksum.Checksum == d.entry.Checksum {
+		d.verified.add(d.entry)
+		return nil
 	}
-	return nil
+	if d.report != nil {
+		d.report.add(d.entry, d.checksum.Checksum)
+		return nil
+	}
+	return d.entry.error(fmt.Errorf("%w: expected %08x, got %08x", ErrChecksumMismatch, d.entry.Checksum, d.checksum.Checksum))
 }
 
 type checksumlessEntry struct {
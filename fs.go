@@ -0,0 +1,256 @@
+package cab
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	_ fs.FS        = (*Cabinet)(nil)
+	_ fs.ReadDirFS = (*Cabinet)(nil)
+	_ fs.StatFS    = (*Cabinet)(nil)
+	_ fs.GlobFS    = (*Cabinet)(nil)
+)
+
+type fsTree struct {
+	files map[string]*File
+	dirs  map[string]*fsDir
+}
+
+type fsDir struct {
+	name    string
+	entries []fs.DirEntry
+}
+
+// fsPath converts a file name from a cabinet to a slash-separated path.
+func fsPath(name string) string {
+	return strings.TrimLeft(strings.ReplaceAll(name, `\`, "/"), "/")
+}
+
+func (cab *Cabinet) tree() *fsTree {
+	cab.fsTreeOnce.Do(func() {
+		tree := &fsTree{
+			files: map[string]*File{},
+			dirs:  map[string]*fsDir{".": {name: "."}},
+		}
+		for _, file := range cab.Files {
+			filePath := fsPath(file.Name)
+			if !fs.ValidPath(filePath) || filePath == "." || tree.exists(filePath) {
+				continue
+			}
+			if !tree.makeParents(filePath) {
+				continue
+			}
+			tree.files[filePath] = file
+			parent := tree.dirs[path.Dir(filePath)]
+			parent.entries = append(parent.entries, fs.FileInfoToDirEntry(FileInfo{file}))
+		}
+		for _, dir := range tree.dirs {
+			sort.Slice(dir.entries, func(i, j int) bool {
+				return dir.entries[i].Name() < dir.entries[j].Name()
+			})
+		}
+		cab.fsTree = tree
+	})
+	return cab.fsTree
+}
+
+func (t *fsTree) exists(name string) bool {
+	_, isFile := t.files[name]
+	_, isDir := t.dirs[name]
+	return isFile || isDir
+}
+
+// makeParents creates all parent directories of name. It returns false if a parent is a file.
+func (t *fsTree) makeParents(name string) bool {
+	parentPath := path.Dir(name)
+	if _, isDir := t.dirs[parentPath]; isDir {
+		return true
+	}
+	if _, isFile := t.files[parentPath]; isFile {
+		return false
+	}
+	if !t.makeParents(parentPath) {
+		return false
+	}
+	dir := &fsDir{name: path.Base(parentPath)}
+	t.dirs[parentPath] = dir
+	grandparent := t.dirs[path.Dir(parentPath)]
+	grandparent.entries = append(grandparent.entries, fs.FileInfoToDirEntry(dirInfo{dir}))
+	return true
+}
+
+// Open opens the named file or directory for reading. Together with ReadDir, Stat and Glob, this implements fs.FS,
+// fs.ReadDirFS, fs.StatFS and fs.GlobFS.
+//
+// Backslashes in file names are treated as path separators, and directories are synthesized from the file names.
+// Files whose names are not valid fs.FS paths after this conversion are not accessible through the fs.FS methods;
+// if several files have the same name, the first one is used. The directory tree is built from Files when one of
+// these methods is called for the first time.
+func (cab *Cabinet) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	tree := cab.tree()
+	if file, isFile := tree.files[name]; isFile {
+		return &fsFile{file: file}, nil
+	}
+	if dir, isDir := tree.dirs[name]; isDir {
+		return &fsDirFile{dir: dir}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir reads the named directory and returns its entries sorted by file name.
+func (cab *Cabinet) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, isDir := cab.tree().dirs[name]
+	if !isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), dir.entries...), nil
+}
+
+// Stat returns a fs.FileInfo describing the named file or directory.
+func (cab *Cabinet) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	tree := cab.tree()
+	if file, isFile := tree.files[name]; isFile {
+		return FileInfo{file}, nil
+	}
+	if dir, isDir := tree.dirs[name]; isDir {
+		return dirInfo{dir}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Glob returns the names of all files and directories matching pattern, using the syntax of path.Match.
+func (cab *Cabinet) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	tree := cab.tree()
+	var matches []string
+	addMatch := func(name string) {
+		if name == "." {
+			return
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			matches = append(matches, name)
+		}
+	}
+	for name := range tree.files {
+		addMatch(name)
+	}
+	for name := range tree.dirs {
+		addMatch(name)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+type fsFile struct {
+	file   *File
+	reader io.ReadCloser
+	closed bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return FileInfo{f.file}, nil
+}
+
+func (f *fsFile) Read(b []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.file.Name, Err: fs.ErrClosed}
+	}
+	if f.reader == nil {
+		reader, err := f.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.reader = reader
+	}
+	return f.reader.Read(b)
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.file.Name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.reader == nil {
+		return nil
+	}
+	return f.reader.Close()
+}
+
+type fsDirFile struct {
+	dir    *fsDir
+	offset int
+}
+
+func (d *fsDirFile) Stat() (fs.FileInfo, error) {
+	return dirInfo{d.dir}, nil
+}
+
+func (d *fsDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.dir.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDirFile) Close() error {
+	return nil
+}
+
+func (d *fsDirFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.dir.entries[d.offset:]
+	if count <= 0 {
+		d.offset += len(remaining)
+		return append([]fs.DirEntry(nil), remaining...), nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return append([]fs.DirEntry(nil), remaining[:count]...), nil
+}
+
+// dirInfo describes a directory that was synthesized from the file names in a cabinet.
+type dirInfo struct {
+	dir *fsDir
+}
+
+func (d dirInfo) Name() string {
+	return d.dir.name
+}
+
+func (d dirInfo) Size() int64 {
+	return 0
+}
+
+func (d dirInfo) Mode() fs.FileMode {
+	return fs.ModeDir | 0555
+}
+
+func (d dirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (d dirInfo) IsDir() bool {
+	return true
+}
+
+func (d dirInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,82 @@
+package cab
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(cabFile, "acpi.sys.mui", "afd.sys.mui"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSDirectories(t *testing.T) {
+	content := testSetContent()
+	volume := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1500], 1500}},
+		files: []testFile{
+			{`drivers\x64\foo.sys`, 0, 500, 0},
+			{`drivers\x86\foo.sys`, 500, 500, 0},
+			{`\readme.txt`, 1000, 500, 0},
+			{`..\escape.txt`, 1000, 500, 0},
+		},
+	})
+	cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(cabFile, "drivers/x64/foo.sys", "drivers/x86/foo.sys", "readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(cabFile, "drivers/x64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Name() != "x64" {
+		t.Fatal("unexpected directory info", info.Name(), info.IsDir())
+	}
+	matches, err := fs.Glob(cabFile, "drivers/*/foo.sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatal("unexpected glob matches", matches)
+	}
+	if _, err := fs.Stat(cabFile, "../escape.txt"); err == nil {
+		t.Fatal("expected invalid path to be inaccessible")
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	for _, test := range []struct {
+		attributes Attributes
+		text       string
+		mode       fs.FileMode
+	}{
+		{0, "--------", 0o644},
+		{AttributeArch, "---A----", 0o644},
+		{AttributeReadOnly | AttributeHidden | AttributeSystem | AttributeArch | AttributeExec | AttributeNameUtf, "RHSA-X-U", 0o555},
+		{AttributeExec | 0x100, "-----X--+0x100", 0o755},
+		{0x8 | 0x10, "----D-V-", 0o644},
+	} {
+		if test.attributes.String() != test.text {
+			t.Error("unexpected string", test.attributes.String(), "expected", test.text)
+		}
+		file := &File{Attributes: test.attributes}
+		if mode := file.Stat().Mode(); mode != test.mode || !mode.IsRegular() {
+			t.Error("unexpected mode", mode, "expected", test.mode)
+		}
+	}
+}
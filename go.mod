@@ -2,4 +2,7 @@ module github.com/secDre4mer/go-cab
 
 go 1.20
 
-require github.com/secDre4mer/lzx v0.0.0-20250826110518-fedea00d45a5
+require (
+	github.com/secDre4mer/lzx v0.0.0-20250826110518-fedea00d45a5
+	golang.org/x/text v0.14.0
+)
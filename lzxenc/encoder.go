@@ -0,0 +1,396 @@
+// Package lzxenc implements LZX compression in the format that cabinet folders use.
+package lzxenc
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+const (
+	// FrameSize is the uncompressed size of a frame. The compressed data of every frame is padded to 16 bits and
+	// stored in its own data block.
+	FrameSize = 1 << 15
+	// blockSize is the uncompressed size of an LZX block. Decoders differ in whether they realign the bitstream when a
+	// block ends at a frame boundary, so blocks must not end there, except at the end of the data. k*blockSize is
+	// only a multiple of FrameSize if k is a multiple of FrameSize, which requires more than 8 TB of data.
+	blockSize = 8*FrameSize - 1
+
+	blockTypeVerbatim = 1
+
+	numChars            = 256
+	numPrimaryLengths   = 7
+	numSecondaryLengths = 249
+	preTreeSize         = 20
+	minMatch            = 2
+	maxMatch            = minMatch + numPrimaryLengths + numSecondaryLengths - 1
+	maxCodeLength       = 16
+	maxPreTreeLength    = 15
+
+	// Matches found by the encoder are at least this long; shorter matches rarely pay off
+	minEncodedMatch = 3
+	hashBits        = 15
+	maxChainLength  = 64
+	// Matches that are at least this long are taken without looking for a longer match at the next position
+	niceMatch = 64
+)
+
+// positionSlots is the number of position slots for window sizes from 2^15 to 2^21.
+var positionSlots = [...]int{30, 32, 34, 36, 38, 42, 50}
+
+var positionBase, positionExtraBits = func() (base [50]int, extraBits [50]int) {
+	for slot := range base {
+		if slot >= 2 {
+			extraBits[slot] = minInt((slot-2)/2, 17)
+		}
+		if slot > 0 {
+			base[slot] = base[slot-1] + 1<<extraBits[slot-1]
+		}
+	}
+	return
+}()
+
+// Encoder compresses the data of a folder with LZX. Intel E8 translation is not used.
+type Encoder struct {
+	windowSize    int
+	positionSlots int
+
+	data      []byte // Preceding data within the window, followed by data that was not encoded yet
+	dataStart int    // Position of data[0] in the uncompressed data
+	position  int    // Position of the next byte to encode
+
+	head   []int32 // Last position of each hash
+	prev   []int32 // Previous position with the same hash, indexed by position modulo windowSize
+	hashed int     // Positions before hashed are in the hash chains
+
+	// Repeated offsets. They stay 0 until a match sets them, since decoders initialize them differently.
+	repeated [3]int
+
+	// Code lengths of the previous block, which the trees of the next block are encoded against
+	mainLengths      []byte
+	secondaryLengths []byte
+
+	bits   bitWriter
+	frames [][]byte // Compressed frames that are complete
+}
+
+// NewEncoder returns an Encoder with a window of 2^windowBits bytes. windowBits must be between 15 and 21.
+func NewEncoder(windowBits int) (*Encoder, error) {
+	if windowBits < 15 || windowBits > 21 {
+		return nil, errors.New("invalid LZX window size")
+	}
+	encoder := &Encoder{
+		windowSize:       1 << windowBits,
+		positionSlots:    positionSlots[windowBits-15],
+		head:             make([]int32, 1<<hashBits),
+		prev:             make([]int32, 1<<windowBits),
+		secondaryLengths: make([]byte, numSecondaryLengths),
+	}
+	encoder.mainLengths = make([]byte, numChars+encoder.positionSlots*8)
+	for i := range encoder.head {
+		encoder.head[i] = -1
+	}
+	// No Intel E8 translation
+	encoder.bits.write(0, 1)
+	return encoder, nil
+}
+
+// Encode compresses the next part of the data and returns the compressed frames that are complete, which may lag
+// behind the data that was passed to Encode. Each frame corresponds to FrameSize bytes of uncompressed data.
+func (e *Encoder) Encode(data []byte) ([][]byte, error) {
+	if e.dataStart+len(e.data)+len(data) > math.MaxInt32 {
+		return nil, errors.New("too much data for LZX encoder")
+	}
+	// Discard data that is no longer within the window, once it takes up as much space as the window
+	if discard := e.position - e.windowSize - e.dataStart; discard >= e.windowSize {
+		e.data = append(e.data[:0], e.data[discard:]...)
+		e.dataStart += discard
+	}
+	e.data = append(e.data, data...)
+	for e.dataStart+len(e.data)-e.position >= blockSize {
+		e.encodeBlock(e.position + blockSize)
+	}
+	return e.takeFrames(), nil
+}
+
+// Flush compresses the remaining data and returns the remaining compressed frames. The last frame corresponds to the
+// remaining uncompressed data, which may be less than FrameSize bytes. The Encoder must not be used afterwards.
+func (e *Encoder) Flush() ([][]byte, error) {
+	end := e.dataStart + len(e.data)
+	if end == 0 {
+		return nil, nil
+	}
+	if e.position < end {
+		e.encodeBlock(end)
+	}
+	e.finishFrame()
+	// Decoders may read ahead by up to 16 bits while decoding the last symbol
+	last := len(e.frames) - 1
+	e.frames[last] = append(e.frames[last], 0, 0)
+	return e.takeFrames(), nil
+}
+
+func (e *Encoder) takeFrames() [][]byte {
+	frames := e.frames
+	e.frames = nil
+	return frames
+}
+
+// finishFrame pads the compressed data of the current frame to 16 bits and completes it.
+func (e *Encoder) finishFrame() {
+	e.bits.align()
+	e.frames = append(e.frames, e.bits.data)
+	e.bits.data = nil
+}
+
+// element is a literal or a match.
+type element struct {
+	main      uint16 // Symbol of the main tree
+	secondary uint8  // Symbol of the length tree, for matches with main symbols that require one
+	extraBits uint8  // Number of verbatim position bits
+	extra     uint32 // Verbatim position bits
+	length    int    // Number of uncompressed bytes
+}
+
+func (e element) hasSecondary() bool {
+	return e.main >= numChars && (e.main-numChars)&numPrimaryLengths == numPrimaryLengths
+}
+
+// encodeBlock encodes the data up to the position end as a verbatim block.
+func (e *Encoder) encodeBlock(end int) {
+	elements := e.parse(end)
+
+	mainFrequencies := make([]int, len(e.mainLengths))
+	secondaryFrequencies := make([]int, numSecondaryLengths)
+	for _, element := range elements {
+		mainFrequencies[element.main]++
+		if element.hasSecondary() {
+			secondaryFrequencies[element.secondary]++
+		}
+	}
+	mainLengths := codeLengths(mainFrequencies, maxCodeLength)
+	secondaryLengths := codeLengths(secondaryFrequencies, maxCodeLength)
+	mainCodes := canonicalCodes(mainLengths)
+	secondaryCodes := canonicalCodes(secondaryLengths)
+
+	e.bits.write(blockTypeVerbatim, 3)
+	e.bits.write(uint32(end-e.position), 24)
+	e.writeLengths(e.mainLengths[:numChars], mainLengths[:numChars])
+	e.writeLengths(e.mainLengths[numChars:], mainLengths[numChars:])
+	e.writeLengths(e.secondaryLengths, secondaryLengths)
+
+	for _, element := range elements {
+		e.bits.write(mainCodes[element.main], int(mainLengths[element.main]))
+		if element.hasSecondary() {
+			e.bits.write(secondaryCodes[element.secondary], int(secondaryLengths[element.secondary]))
+		}
+		if element.extraBits > 0 {
+			e.bits.write(element.extra, int(element.extraBits))
+		}
+		e.position += element.length
+		// Elements never cross frame boundaries. The frame that ends with the block is completed by Flush.
+		if e.position%FrameSize == 0 && e.position != end {
+			e.finishFrame()
+		}
+	}
+}
+
+// writeLengths writes code lengths, encoded as differences to the previous lengths with a pretree, and stores them
+// in previous.
+func (e *Encoder) writeLengths(previous, lengths []byte) {
+	type preTreeElement struct {
+		symbol    uint8
+		extra     uint32
+		extraBits int
+	}
+	var elements []preTreeElement
+	for i := 0; i < len(lengths); {
+		zeros := 0
+		for i+zeros < len(lengths) && lengths[i+zeros] == 0 && zeros < 51 {
+			zeros++
+		}
+		switch {
+		case zeros >= 20:
+			elements = append(elements, preTreeElement{symbol: 18, extra: uint32(zeros - 20), extraBits: 5})
+			i += zeros
+		case zeros >= 4:
+			elements = append(elements, preTreeElement{symbol: 17, extra: uint32(zeros - 4), extraBits: 4})
+			i += zeros
+		default:
+			elements = append(elements, preTreeElement{symbol: (previous[i] + 17 - lengths[i]) % 17})
+			i++
+		}
+	}
+
+	frequencies := make([]int, preTreeSize)
+	for _, element := range elements {
+		frequencies[element.symbol]++
+	}
+	preTreeLengths := codeLengths(frequencies, maxPreTreeLength)
+	preTreeCodes := canonicalCodes(preTreeLengths)
+	for _, length := range preTreeLengths {
+		e.bits.write(uint32(length), 4)
+	}
+	for _, element := range elements {
+		e.bits.write(preTreeCodes[element.symbol], int(preTreeLengths[element.symbol]))
+		if element.extraBits > 0 {
+			e.bits.write(element.extra, element.extraBits)
+		}
+	}
+	copy(previous, lengths)
+}
+
+// parse finds the literals and matches that encode the data up to the position end.
+func (e *Encoder) parse(end int) []element {
+	var elements []element
+	position := e.position
+	for position < end {
+		e.insertHashes(position)
+		limit := minInt(end, (position/FrameSize+1)*FrameSize) - position
+		length, offset, repeated := e.findMatch(position, limit)
+		if length >= minEncodedMatch && length < niceMatch && limit > 1 {
+			// Prefer a literal if the next position has a clearly longer match
+			e.insertHashes(position + 1)
+			if nextLength, _, _ := e.findMatch(position+1, limit-1); nextLength > length+1 {
+				length = 0
+			}
+		}
+		if length < minEncodedMatch {
+			elements = append(elements, element{main: uint16(e.byteAt(position)), length: 1})
+			position++
+		} else {
+			elements = append(elements, e.match(length, offset, repeated))
+			position += length
+		}
+	}
+	return elements
+}
+
+// match returns the element for a match and updates the repeated offsets. repeated is the index of the repeated
+// offset, or -1.
+func (e *Encoder) match(length, offset, repeated int) element {
+	var slot int
+	var match element
+	switch repeated {
+	case 0:
+	case 1, 2:
+		slot = repeated
+		e.repeated[0], e.repeated[repeated] = e.repeated[repeated], e.repeated[0]
+	default:
+		formatted := offset + 2
+		slot = sort.Search(e.positionSlots, func(slot int) bool {
+			return positionBase[slot] > formatted
+		}) - 1
+		match.extraBits = uint8(positionExtraBits[slot])
+		match.extra = uint32(formatted - positionBase[slot])
+		e.repeated = [3]int{offset, e.repeated[0], e.repeated[1]}
+	}
+	lengthHeader := minInt(length-minMatch, numPrimaryLengths)
+	match.main = uint16(numChars + slot*8 + lengthHeader)
+	if lengthHeader == numPrimaryLengths {
+		match.secondary = uint8(length - minMatch - numPrimaryLengths)
+	}
+	match.length = length
+	return match
+}
+
+// findMatch returns the longest match at position that is at most limit bytes long. If a repeated offset matches
+// about as well as the longest match, it is preferred, and its index is returned; otherwise the index is -1.
+func (e *Encoder) findMatch(position, limit int) (length, offset, repeated int) {
+	limit = minInt(limit, maxMatch)
+	if limit < minEncodedMatch {
+		return 0, 0, -1
+	}
+	maxOffset := minInt(e.windowSize-3, position-e.dataStart)
+
+	candidate := int(e.head[e.hash(position)])
+	for chain := 0; chain < maxChainLength && candidate >= 0 && candidate < position && position-candidate <= maxOffset; chain++ {
+		if candidateLength := e.matchLength(candidate, position, limit); candidateLength > length {
+			length, offset = candidateLength, position-candidate
+			if length == limit {
+				break
+			}
+		}
+		next := int(e.prev[candidate%e.windowSize])
+		if next >= candidate {
+			break
+		}
+		candidate = next
+	}
+
+	repeated = -1
+	for i, repeatedOffset := range e.repeated {
+		if repeatedOffset == 0 || repeatedOffset > maxOffset {
+			continue
+		}
+		if repeatedLength := e.matchLength(position-repeatedOffset, position, limit); repeatedLength >= minEncodedMatch && repeatedLength+1 >= length {
+			length, offset, repeated = repeatedLength, repeatedOffset, i
+			break
+		}
+	}
+	return length, offset, repeated
+}
+
+func (e *Encoder) matchLength(from, position, limit int) int {
+	a := e.data[from-e.dataStart:]
+	b := e.data[position-e.dataStart : position-e.dataStart+limit]
+	length := 0
+	for length < len(b) && a[length] == b[length] {
+		length++
+	}
+	return length
+}
+
+func (e *Encoder) byteAt(position int) byte {
+	return e.data[position-e.dataStart]
+}
+
+func (e *Encoder) hash(position int) int {
+	if position+minEncodedMatch > e.dataStart+len(e.data) {
+		return 0
+	}
+	data := e.data[position-e.dataStart:]
+	return int((uint32(data[0])<<16|uint32(data[1])<<8|uint32(data[2]))*2654435761) >> (32 - hashBits)
+}
+
+// insertHashes adds the positions before end to the hash chains.
+func (e *Encoder) insertHashes(end int) {
+	end = minInt(end, e.dataStart+len(e.data)-minEncodedMatch+1)
+	for ; e.hashed < end; e.hashed++ {
+		hash := e.hash(e.hashed)
+		e.prev[e.hashed%e.windowSize] = e.head[hash]
+		e.head[hash] = int32(e.hashed)
+	}
+}
+
+// bitWriter writes bits in the order of LZX: 16-bit little endian words, each filled from the most significant bit.
+type bitWriter struct {
+	data   []byte
+	buffer uint64
+	count  int // Number of bits in buffer
+}
+
+func (w *bitWriter) write(value uint32, bits int) {
+	w.buffer = w.buffer<<bits | uint64(value)
+	w.count += bits
+	for w.count >= 16 {
+		w.count -= 16
+		word := uint16(w.buffer >> w.count)
+		w.data = append(w.data, byte(word), byte(word>>8))
+	}
+	w.buffer &= 1<<w.count - 1
+}
+
+// align pads the data to 16 bits.
+func (w *bitWriter) align() {
+	if w.count > 0 {
+		w.write(0, 16-w.count)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package lzxenc
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/secDre4mer/lzx"
+)
+
+func testData() map[string][]byte {
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 100000)
+	random.Read(noise)
+	var text bytes.Buffer
+	words := []string{"cabinet", "folder", "file", "data", "block", "LZX", "window", "frame", "\n"}
+	for text.Len() < 600000 {
+		text.WriteString(words[random.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+	// Repeats a chunk at a distance that only fits into large windows
+	var distant []byte
+	distant = append(distant, noise[:50000]...)
+	distant = append(distant, make([]byte, 1<<20)...)
+	distant = append(distant, noise[:50000]...)
+	return map[string][]byte{
+		"empty":    {},
+		"short":    []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		"frame":    bytes.Repeat([]byte("0123456789abcdef"), FrameSize/16),
+		"noise":    noise,
+		"text":     text.Bytes(),
+		"blocks":   bytes.Repeat(noise[:1000], 3*blockSize/1000+7),
+		"distant":  distant,
+		"zeros":    make([]byte, 3*FrameSize),
+		"unframed": text.Bytes()[:5*FrameSize+1],
+	}
+}
+
+// encode compresses data, passing it to the encoder in chunks of the given size.
+func encode(t *testing.T, windowBits int, data []byte, chunkSize int) [][]byte {
+	encoder, err := NewEncoder(windowBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames [][]byte
+	for len(data) > 0 {
+		chunk := data[:minInt(chunkSize, len(data))]
+		data = data[len(chunk):]
+		encoded, err := encoder.Encode(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, encoded...)
+	}
+	encoded, err := encoder.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(frames, encoded...)
+}
+
+// TestEncoder decodes the output with github.com/secDre4mer/lzx. The cab package checks cabinets written with this
+// encoder against other decoders, see TestWriterLZXCabextract and TestLZXWindowsCabinet there.
+func TestEncoder(t *testing.T) {
+	for windowBits := 15; windowBits <= 21; windowBits++ {
+		for name, data := range testData() {
+			frames := encode(t, windowBits, data, FrameSize)
+			if len(frames) != (len(data)+FrameSize-1)/FrameSize {
+				t.Fatal(name, windowBits, "unexpected number of frames", len(frames))
+			}
+			if len(data) == 0 {
+				continue
+			}
+			for _, frame := range frames {
+				if len(frame) > 0xFFFF || len(frame)%2 != 0 {
+					t.Fatal(name, windowBits, "invalid frame size", len(frame))
+				}
+			}
+			reader, err := lzx.New(bytes.NewReader(bytes.Join(frames, nil)), 1<<windowBits, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(io.LimitReader(reader, int64(len(data))))
+			if err != nil {
+				t.Fatal(name, windowBits, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatal(name, windowBits, "decoded data differs")
+			}
+		}
+	}
+}
+
+func TestEncoderChunks(t *testing.T) {
+	data := testData()["text"]
+	expected := encode(t, 16, data, FrameSize)
+	for _, chunkSize := range []int{1000, blockSize, len(data)} {
+		if frames := encode(t, 16, data, chunkSize); !bytes.Equal(bytes.Join(frames, nil), bytes.Join(expected, nil)) {
+			t.Fatal("output depends on chunk size", chunkSize)
+		}
+	}
+}
+
+func TestEncoderRatio(t *testing.T) {
+	data := testData()["text"]
+	compressed := len(bytes.Join(encode(t, 21, data, FrameSize), nil))
+	if compressed > len(data)/3 {
+		t.Fatalf("compressed %d bytes to %d bytes", len(data), compressed)
+	}
+}
+
+func TestNewEncoderWindowSize(t *testing.T) {
+	for _, windowBits := range []int{14, 22} {
+		if _, err := NewEncoder(windowBits); err == nil {
+			t.Fatal("expected error for window size", windowBits)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lzxenc
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// codeLengths computes the lengths of a Huffman code for the given symbol frequencies, limited to maxLength bits.
+// The code is always complete and has at least two symbols, since decoders reject other codes.
+func codeLengths(frequencies []int, maxLength int) []byte {
+	frequencies = append([]int(nil), frequencies...)
+	used := 0
+	for _, frequency := range frequencies {
+		if frequency > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2; i++ {
+		if frequencies[i] == 0 {
+			frequencies[i] = 1
+			used++
+		}
+	}
+	for {
+		lengths := huffmanLengths(frequencies)
+		var longest byte
+		for _, length := range lengths {
+			if length > longest {
+				longest = length
+			}
+		}
+		if int(longest) <= maxLength {
+			return lengths
+		}
+		// Flatten the distribution until the code is short enough
+		for i, frequency := range frequencies {
+			if frequency > 0 {
+				frequencies[i] = frequency/2 + 1
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	frequency   int
+	symbol      int // Symbol of a leaf, or -1
+	left, right *huffmanNode
+}
+
+type huffmanQueue []*huffmanNode
+
+func (q huffmanQueue) Len() int           { return len(q) }
+func (q huffmanQueue) Less(i, j int) bool { return q[i].frequency < q[j].frequency }
+func (q huffmanQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *huffmanQueue) Push(x any)        { *q = append(*q, x.(*huffmanNode)) }
+func (q *huffmanQueue) Pop() any {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// huffmanLengths computes the lengths of an unrestricted Huffman code. Symbols with frequency 0 get length 0.
+func huffmanLengths(frequencies []int) []byte {
+	var queue huffmanQueue
+	for symbol, frequency := range frequencies {
+		if frequency > 0 {
+			queue = append(queue, &huffmanNode{frequency: frequency, symbol: symbol})
+		}
+	}
+	heap.Init(&queue)
+	for queue.Len() > 1 {
+		left := heap.Pop(&queue).(*huffmanNode)
+		right := heap.Pop(&queue).(*huffmanNode)
+		heap.Push(&queue, &huffmanNode{frequency: left.frequency + right.frequency, symbol: -1, left: left, right: right})
+	}
+	lengths := make([]byte, len(frequencies))
+	var assign func(node *huffmanNode, depth byte)
+	assign = func(node *huffmanNode, depth byte) {
+		if node.symbol >= 0 {
+			lengths[node.symbol] = depth
+			return
+		}
+		assign(node.left, depth+1)
+		assign(node.right, depth+1)
+	}
+	assign(queue[0], 0)
+	return lengths
+}
+
+// canonicalCodes assigns the codes of a canonical Huffman code: shorter codes come first, and codes of the same
+// length are assigned in symbol order.
+func canonicalCodes(lengths []byte) []uint32 {
+	symbols := make([]int, 0, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return lengths[symbols[i]] < lengths[symbols[j]]
+	})
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	var previousLength byte
+	for _, symbol := range symbols {
+		code <<= lengths[symbol] - previousLength
+		previousLength = lengths[symbol]
+		codes[symbol] = code
+		code++
+	}
+	return codes
+}
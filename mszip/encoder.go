@@ -0,0 +1,36 @@
+package mszip
+
+import (
+	"bytes"
+	"compress/flate"
+)
+
+// Encoder compresses the data blocks of a folder, in the format that New reads: every block starts with the "CK"
+// signature, followed by a complete DEFLATE stream that may refer to the uncompressed data of the preceding block.
+type Encoder struct {
+	level int
+	dict  []byte
+}
+
+// NewEncoder returns an Encoder with the given compression level, see compress/flate.
+func NewEncoder(level int) *Encoder {
+	return &Encoder{level: level}
+}
+
+// Encode compresses the next block of the folder. Blocks must not be larger than 32 KB, the DEFLATE window size.
+func (e *Encoder) Encode(block []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	compressed.WriteString("CK")
+	writer, err := flate.NewWriterDict(&compressed, e.level, e.dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(block); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	e.dict = append(e.dict[:0], block...)
+	return compressed.Bytes(), nil
+}
@@ -7,8 +7,15 @@ import (
 )
 
 func New(blocks []io.ReadCloser) io.ReadCloser {
+	return NewWithDictionary(blocks, nil)
+}
+
+// NewWithDictionary returns a reader that starts decompression in the middle of a folder. dict must contain the
+// uncompressed data of the block preceding the first block.
+func NewWithDictionary(blocks []io.ReadCloser, dict []byte) io.ReadCloser {
 	return &msZipReader{
 		blocks: blocks,
+		dict:   dict,
 		lastReadBytes: ringBuffer{
 			buf: make([]byte, 0, maxWindow),
 		},
@@ -0,0 +1,34 @@
+package cab
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// CodePageDecoder returns a NameDecoder that decodes names from the given encoding, e.g. one of the code pages in
+// golang.org/x/text/encoding/charmap. Bytes that are not valid in the encoding are replaced with U+FFFD.
+func CodePageDecoder(enc encoding.Encoding) NameDecoder {
+	return func(name []byte) (string, error) {
+		decoded, err := enc.NewDecoder().Bytes(name)
+		if err != nil {
+			return "", err
+		}
+		return string(decoded), nil
+	}
+}
+
+// DecodeCP437 decodes names in code page 437, the OEM code page of US MS-DOS and Windows.
+func DecodeCP437(name []byte) (string, error) {
+	return CodePageDecoder(charmap.CodePage437)(name)
+}
+
+// DecodeCP1252 decodes names in code page 1252, the ANSI code page of Western European Windows.
+func DecodeCP1252(name []byte) (string, error) {
+	return CodePageDecoder(charmap.Windows1252)(name)
+}
+
+// DecodeCP932 decodes names in code page 932 (Shift JIS), the ANSI code page of Japanese Windows.
+func DecodeCP932(name []byte) (string, error) {
+	return CodePageDecoder(japanese.ShiftJIS)(name)
+}
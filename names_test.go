@@ -0,0 +1,57 @@
+package cab
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestNameDecoders(t *testing.T) {
+	for _, test := range []struct {
+		decoder NameDecoder
+		name    string
+		decoded string
+	}{
+		{DecodeCP437, "\x81ber\\\x9a.txt", "über\\Ü.txt"},
+		{DecodeCP1252, "caf\xe9.txt", "café.txt"},
+		{DecodeCP932, "\x93\xfa\x96\x7b.txt", "日本.txt"},
+	} {
+		cabData := buildTestVolume(testVolume{
+			blocks: []testBlock{{testSetContent()[:100], 100}},
+			files:  []testFile{{test.name, 0, 100, 0}},
+		})
+		cabFile, err := OpenWithOptions(bytes.NewReader(cabData), int64(len(cabData)), &Options{NameDecoder: test.decoder})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := cabFile.Files[0]
+		if file.Name != test.decoded || string(file.RawName) != test.name {
+			t.Errorf("unexpected name %q (raw %q), expected %q", file.Name, file.RawName, test.decoded)
+		}
+	}
+}
+
+func TestNameUtf(t *testing.T) {
+	entry := cabinetFileEntry{fileName: "caf\xc3\xa9.txt"}
+	entry.Attributes = AttributeNameUtf
+	// The decoder is not used for UTF-8 names
+	file, err := (&Options{NameDecoder: DecodeCP1252}).newFile(entry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "café.txt" {
+		t.Fatal("unexpected name", file.Name)
+	}
+
+	entry.fileName = "caf\xe9.txt"
+	file, err = (&Options{}).newFile(entry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "caf�.txt" || string(file.RawName) != entry.fileName {
+		t.Fatalf("unexpected name %q (raw %q)", file.Name, file.RawName)
+	}
+	if _, err := (&Options{Strict: true}).newFile(entry, nil); !errors.Is(err, ErrInvalidName) {
+		t.Fatal("expected invalid name error, got", err)
+	}
+}
@@ -1,41 +1,163 @@
 package cab
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/secDre4mer/lzx"
 	"github.com/secDre4mer/go-cab/mszip"
+	"github.com/secDre4mer/go-cab/quantum"
 )
 
 const compressionTypeMask = 0xF
 
-func (folder cabinetFileFolder) open() (io.ReadCloser, error) {
-	var dataReaders = make([]io.ReadCloser, len(folder.dataEntries))
+func (folder cabinetFileFolder) open(ctx context.Context) (io.ReadCloser, error) {
+	return folder.openAt(ctx, 0, len(folder.dataEntries), nil)
+}
+
+// blockStarts returns the uncompressed offset of each data block in the folder.
+func (folder *cabinetFileFolder) blockStarts() []int64 {
+	starts := make([]int64, len(folder.dataEntries))
+	var offset int64
 	for i := range folder.dataEntries {
-		dataReader, err := openFileData(&folder.dataEntries[i])
+		starts[i] = offset
+		offset += int64(folder.dataEntries[i].uncompressedSize())
+	}
+	return starts
+}
+
+// checkpoint is the state of a decompressor at the start of a data block, from which decompression can restart.
+type checkpoint struct {
+	dictionary []byte         // MSZIP: uncompressed data of the preceding block
+	quantum    *quantum.State // Quantum: state after the preceding block
+}
+
+// openAt opens the folder for reading, starting at the given data block. This is only possible for uncompressed
+// folders, and for MSZIP and Quantum compressed folders with a checkpoint saved at that block.
+// Reading fails with ctx.Err() once ctx is done.
+//
+// Checksums are only verified for the data blocks before endBlock; blocks from endBlock on may still be read ahead
+// by the decompressor, but they are not needed by the caller.
+func (folder cabinetFileFolder) openAt(ctx context.Context, firstBlock, endBlock int, restart *checkpoint) (io.ReadCloser, error) {
+	if folder.streamed {
+		return nil, ErrStreamedFile
+	}
+	if folder.continuedFromPrevious {
+		return nil, folderError(folder.index, -1, ErrMissingVolume)
+	}
+	compressionType := folder.CompressionType & compressionTypeMask
+	if firstBlock != 0 && compressionType != compressionTypeNone && compressionType != compressionTypeMszip &&
+		(compressionType != compressionTypeQuantum || restart == nil || restart.quantum == nil) {
+		return nil, errors.New("decompression can only start at the beginning of the folder")
+	}
+	dataEntries := folder.dataEntries[firstBlock:]
+	unverified := *folder.options
+	unverified.Checksums = ChecksumIgnore
+	var dataReaders = make([]io.ReadCloser, len(dataEntries))
+	for i := range dataEntries {
+		options := folder.options
+		if firstBlock+i >= endBlock {
+			options = &unverified
+		}
+		dataReader, err := openFileData(&dataEntries[i], options)
 		if err != nil {
+			(&multiReader{Readers: dataReaders[:i]}).Close()
 			return nil, err
 		}
-		dataReaders[i] = dataReader
+		dataReaders[i] = &contextReader{ctx, dataReader}
+	}
+	blockSize := func(block int) (int, error) {
+		return dataEntries[block].uncompressedSize(), nil
+	}
+	var declaredSize func() int64
+	if folder.options.Limits.limitsData() {
+		var size int64
+		for i := range dataEntries {
+			size += int64(dataEntries[i].uncompressedSize())
+		}
+		declaredSize = func() int64 {
+			return size
+		}
+	}
+	return newDecompressor(ctx, &folder, dataReaders, blockSize, declaredSize, restart)
+}
+
+// newDecompressor returns a reader for the uncompressed data of a folder. blockSize returns the uncompressed size of
+// a data block, and restart is the checkpoint for folders that are not read from the beginning.
+// Decompression errors are returned as FormatError.
+//
+// If declaredSize is not nil, it returns the uncompressed size declared by the data blocks that were read so far;
+// the decompressor fails if it returns more data than that. Reading fails with ctx.Err() once ctx is done.
+//
+// The data readers are closed when the returned reader is closed, or immediately if an error is returned.
+func newDecompressor(ctx context.Context, folder *cabinetFileFolder, dataReaders []io.ReadCloser, blockSize func(block int) (int, error), declaredSize func() int64, restart *checkpoint) (io.ReadCloser, error) {
+	if restart == nil {
+		restart = &checkpoint{}
 	}
-	switch folder.CompressionType & compressionTypeMask {
+	compressionType := folder.CompressionType
+	var decompressor io.ReadCloser
+	switch compressionType & compressionTypeMask {
 	case compressionTypeNone:
-		return &multiReader{Readers: dataReaders}, nil
+		decompressor = &multiReader{Readers: dataReaders}
 	case compressionTypeMszip:
-		return mszip.New(dataReaders), nil
+		decompressor = mszip.NewWithDictionary(dataReaders, restart.dictionary)
 	case compressionTypeQuantum:
-		return nil, errors.New("quantum compression is not supported yet")
+		// Bits 4-7 contain the compression level, which is irrelevant for decompression
+		windowBits := int((compressionType >> 8) & 0x1F)
+		if restart.quantum != nil {
+			decompressor = quantum.Resume(restart.quantum, dataReaders, blockSize)
+			break
+		}
+		var err error
+		if decompressor, err = quantum.NewWithBlockSize(dataReaders, blockSize, windowBits); err != nil {
+			(&multiReader{Readers: dataReaders}).Close()
+			return nil, folderError(folder.index, -1, fmt.Errorf("%w: %w", ErrUnsupportedCompression, err))
+		}
 	case compressionTypeLzx:
-		windowSize := 1 << int((folder.CompressionType>>8)&0x1F)
-		lzxReader, err := lzx.New(&multiReader{Readers: dataReaders}, int(windowSize), 0)
+		windowSize := 1 << int((compressionType>>8)&0x1F)
+		blocks := &multiReader{Readers: dataReaders}
+		lzxReader, err := lzx.New(blocks, int(windowSize), 0)
 		if err != nil {
-			return nil, err
+			blocks.Close()
+			return nil, folderError(folder.index, -1, fmt.Errorf("%w: %w", ErrUnsupportedCompression, err))
 		}
-		return io.NopCloser(lzxReader), nil
+		// The LZX reader does not close its input
+		decompressor = struct {
+			io.Reader
+			io.Closer
+		}{lzxReader, blocks}
 	default:
-		return nil, errors.New("unknown compression type")
+		(&multiReader{Readers: dataReaders}).Close()
+		return nil, folderError(folder.index, -1, fmt.Errorf("%w: %d", ErrUnsupportedCompression, compressionType&compressionTypeMask))
 	}
+	return &folderDataReader{ReadCloser: decompressor, ctx: ctx, folderIndex: folder.index, declaredSize: declaredSize}, nil
+}
+
+// folderDataReader converts errors of a decompressor to FormatError.
+type folderDataReader struct {
+	io.ReadCloser
+	ctx          context.Context
+	folderIndex  int
+	declaredSize func() int64
+	read         int64
+}
+
+func (r *folderDataReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.read += int64(n)
+	if r.declaredSize != nil && r.read > r.declaredSize() {
+		return 0, corruptData(r.folderIndex, errors.New("decompressed data exceeds the declared size"))
+	}
+	return n, corruptData(r.folderIndex, err)
+}
+
+func (r *folderDataReader) Close() error {
+	return corruptData(r.folderIndex, r.ReadCloser.Close())
 }
 
 const (
@@ -44,3 +166,16 @@ const (
 	compressionTypeQuantum = 2
 	compressionTypeLzx     = 3
 )
+
+// contextReader fails with ctx.Err() once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(b)
+}
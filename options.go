@@ -0,0 +1,208 @@
+package cab
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Options configures how a cabinet is opened. A nil *Options is equivalent to the zero value, which is what Open
+// uses.
+type Options struct {
+	// Strict enables layout checks that are not required by the specification, but that other implementations rely
+	// on: the CFFILE entries must directly follow the CFFOLDER entries.
+	Strict bool
+	// Location is the time zone that file timestamps are interpreted in. If nil, time.Local is used.
+	Location *time.Location
+	// Checksums controls how the checksums of CFDATA blocks are handled.
+	Checksums ChecksumPolicy
+	// CacheVerifiedBlocks remembers the data blocks whose checksums matched, so that they are not verified again
+	// when other files of the same folder are read. The cache is shared by all readers of a cabinet or set.
+	CacheVerifiedBlocks bool
+	// Limits restricts the resources that are used for a cabinet.
+	Limits Limits
+	// NameDecoder converts file names that do not have AttributeNameUtf set, e.g. DecodeCP437 or DecodeCP1252. If
+	// nil, names are used as stored, which may not be valid UTF-8.
+	//
+	// Names with AttributeNameUtf set are validated instead: invalid UTF-8 sequences are replaced with U+FFFD, or
+	// fail with ErrInvalidName if Strict is set.
+	NameDecoder NameDecoder
+
+	report   *checksumReport // Shared by all cabinets of a set for ChecksumReport
+	verified *verifiedBlocks // Shared by all cabinets of a set for CacheVerifiedBlocks
+}
+
+// ChecksumPolicy controls how the checksums of CFDATA blocks are handled.
+type ChecksumPolicy int
+
+const (
+	// ChecksumStrict verifies checksums when data blocks are read; a mismatch causes reading to fail with
+	// ErrChecksumMismatch.
+	ChecksumStrict ChecksumPolicy = iota
+	// ChecksumIgnore does not verify checksums.
+	ChecksumIgnore
+	// ChecksumReport verifies checksums, but continues reading if they do not match. The mismatches are collected
+	// and can be retrieved with Cabinet.ChecksumMismatches or StreamReader.ChecksumMismatches.
+	ChecksumReport
+
+	// ChecksumVerify is the former name of ChecksumStrict.
+	//
+	// Deprecated: Use ChecksumStrict.
+	ChecksumVerify = ChecksumStrict
+)
+
+// Limits restricts the resources that are used for a cabinet. Zero values mean that there is no limit.
+// Each limit fails with a dedicated error when it is exceeded; all of them match ErrLimitExceeded.
+//
+// For multi-cabinet sets, the limits apply to each cabinet separately. A StreamReader checks MaxUncompressedSize and
+// MaxCompressionRatio for the data blocks that were read so far, since the data blocks of a folder are only known
+// once they are read.
+type Limits struct {
+	MaxFiles      int // Maximum number of CFFILE entries, see ErrTooManyFiles
+	MaxFolders    int // Maximum number of CFFOLDER entries, see ErrTooManyFolders
+	MaxDataBlocks int // Maximum number of CFDATA blocks in all folders, see ErrTooManyDataBlocks
+	// MaxUncompressedSize is the maximum of both the total size of all files and the total uncompressed size of
+	// all data blocks, as declared in the cabinet. See ErrUncompressedSizeLimit.
+	MaxUncompressedSize int64
+	// MaxCompressionRatio is the maximum ratio between the declared uncompressed and the compressed size of a
+	// folder. See ErrCompressionRatioLimit.
+	MaxCompressionRatio float64
+	// MaxWindowSize is the maximum LZX or Quantum window size in bytes. See ErrWindowSizeLimit.
+	MaxWindowSize int
+	// MaxNameLength is the maximum length of file and cabinet names in bytes. See ErrNameTooLong.
+	MaxNameLength int
+}
+
+// limitsData reports whether limits for the uncompressed data are set. In that case, decompressors must not return
+// more data than the data blocks declare.
+func (l Limits) limitsData() bool {
+	return l.MaxUncompressedSize > 0 || l.MaxCompressionRatio > 0
+}
+
+// NameDecoder converts a file name, as stored in the cabinet, to a string.
+type NameDecoder func(name []byte) (string, error)
+
+// normalized returns a copy of the options with defaults applied.
+func (o *Options) normalized() Options {
+	var options Options
+	if o != nil {
+		options = *o
+	}
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+	if options.Checksums == ChecksumReport && options.report == nil {
+		options.report = &checksumReport{}
+	}
+	if options.CacheVerifiedBlocks && options.verified == nil {
+		options.verified = &verifiedBlocks{}
+	}
+	return options
+}
+
+// checkHeader checks the number of files and folders in CFHEADER.
+func (o *Options) checkHeader(cfHeader cabinetFileHeader) error {
+	if o.Limits.MaxFiles > 0 && int(cfHeader.FileCount) > o.Limits.MaxFiles {
+		return headerError(0, ErrTooManyFiles)
+	}
+	if o.Limits.MaxFolders > 0 && int(cfHeader.FolderCount) > o.Limits.MaxFolders {
+		return headerError(0, ErrTooManyFolders)
+	}
+	return nil
+}
+
+// checkDirectory applies the layout checks and limits to the CFFOLDER entries of a cabinet. postFolderOffset is the
+// offset directly after the CFFOLDER entries.
+func (o *Options) checkDirectory(cfHeader cabinetFileHeader, folders []cabinetFileFolder, postFolderOffset int64) error {
+	if o.Strict && int64(cfHeader.FirstFileEntryOffset) != postFolderOffset {
+		return fileError(0, int64(cfHeader.FirstFileEntryOffset), fmt.Errorf("%w: offset between CFFOLDER and CFFILE", ErrInvalidLayout))
+	}
+	var dataBlocks int
+	for i, folder := range folders {
+		dataBlocks += int(folder.CfDataCount)
+		if o.Limits.MaxDataBlocks > 0 && dataBlocks > o.Limits.MaxDataBlocks {
+			return folderError(i, -1, ErrTooManyDataBlocks)
+		}
+		if o.Limits.MaxWindowSize > 0 {
+			compressionType := folder.CompressionType & compressionTypeMask
+			windowSize := 1 << int((folder.CompressionType>>8)&0x1F)
+			if (compressionType == compressionTypeLzx || compressionType == compressionTypeQuantum) && windowSize > o.Limits.MaxWindowSize {
+				return folderError(i, -1, ErrWindowSizeLimit)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFolderData checks the declared sizes of the data blocks of all folders.
+func (o *Options) checkFolderData(folders []cabinetFileFolder) error {
+	var total int64
+	for i, folder := range folders {
+		var compressed, uncompressed int64
+		for _, entry := range folder.dataEntries {
+			compressed += int64(entry.CompressedBytes)
+			uncompressed += int64(entry.UncompressedBytes)
+		}
+		if err := o.checkCompressionRatio(compressed, uncompressed); err != nil {
+			return folderError(i, int64(folder.CoffCabStart), err)
+		}
+		total += uncompressed
+		if o.Limits.MaxUncompressedSize > 0 && total > o.Limits.MaxUncompressedSize {
+			return folderError(i, int64(folder.CoffCabStart), ErrUncompressedSizeLimit)
+		}
+	}
+	return nil
+}
+
+func (o *Options) checkCompressionRatio(compressed, uncompressed int64) error {
+	if o.Limits.MaxCompressionRatio > 0 && float64(uncompressed) > float64(compressed)*o.Limits.MaxCompressionRatio {
+		return ErrCompressionRatioLimit
+	}
+	return nil
+}
+
+// checkFiles checks the declared sizes of all files.
+func (o *Options) checkFiles(fileEntries []cabinetFileEntry) error {
+	if o.Limits.MaxUncompressedSize <= 0 {
+		return nil
+	}
+	var total int64
+	for _, fileEntry := range fileEntries {
+		total += int64(fileEntry.UncompressedFileSize)
+		if total > o.Limits.MaxUncompressedSize {
+			return fileError(fileEntry.index, fileEntry.offset, ErrUncompressedSizeLimit)
+		}
+	}
+	return nil
+}
+
+// newFile creates a File from a CFFILE entry.
+func (o *Options) newFile(fileEntry cabinetFileEntry, folder *cabinetFileFolder) (*File, error) {
+	name := fileEntry.fileName
+	if fileEntry.Attributes&AttributeNameUtf != 0 {
+		if !utf8.ValidString(name) {
+			if o.Strict {
+				return nil, fileError(fileEntry.index, fileEntry.offset, ErrInvalidName)
+			}
+			name = strings.ToValidUTF8(name, "\uFFFD")
+		}
+	} else if o.NameDecoder != nil {
+		var err error
+		if name, err = o.NameDecoder([]byte(name)); err != nil {
+			return nil, fileError(fileEntry.index, fileEntry.offset, err)
+		}
+	}
+	modified, valid := parseCabTimestamp(fileEntry.Date, fileEntry.Time, o.Location)
+	return &File{
+		Name:             name,
+		RawName:          []byte(fileEntry.fileName),
+		Modified:         modified,
+		DOSDate:          fileEntry.Date,
+		DOSTime:          fileEntry.Time,
+		InvalidTimestamp: !valid,
+		Attributes:       fileEntry.Attributes,
+		folder:           folder,
+		header:           fileEntry.cabinetFileEntryHeader,
+	}, nil
+}
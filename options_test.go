@@ -0,0 +1,214 @@
+package cab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenWithOptionsStrict(t *testing.T) {
+	content := testSetContent()
+	cabData := buildTestVolume(testVolume{
+		blocks:         []testBlock{{content[:1000], 1000}},
+		files:          []testFile{{"file.bin", 0, 1000, 0}},
+		filesAfterData: true,
+	})
+	if _, err := OpenWithOptions(bytes.NewReader(cabData), int64(len(cabData)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWithOptions(bytes.NewReader(cabData), int64(len(cabData)), &Options{Strict: true}); err == nil {
+		t.Fatal("expected error for CFFILE entries after CFDATA in strict mode")
+	}
+}
+
+func TestOpenWithOptionsLocation(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), &Options{Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := cabFile.Files[0]
+	if !file.Modified.Equal(time.Date(2021, 11, 02, 14, 34, 56, 0, time.UTC)) || file.Modified.Location() != time.UTC {
+		t.Fatal(file.Modified)
+	}
+	if file.DOSDate != 0x5362 || file.DOSTime != 0x745c || file.InvalidTimestamp {
+		t.Fatalf("unexpected raw timestamp %04x %04x", file.DOSDate, file.DOSTime)
+	}
+}
+
+func TestOpenWithOptionsChecksums(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the checksum of the first CFDATA block
+	dataOffset := binary.LittleEndian.Uint32(testfileData[36:])
+	actual := binary.LittleEndian.Uint32(testfileData[dataOffset:])
+	testfileData[dataOffset] ^= 0xFF
+
+	readAll := func(opts *Options) (*Cabinet, error) {
+		cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := cabFile.Files[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(reader)
+		return cabFile, err
+	}
+	cabFile, err := readAll(nil)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatal("expected checksum mismatch", err)
+	}
+	if len(cabFile.ChecksumMismatches()) != 0 {
+		t.Fatal("mismatches should only be collected with ChecksumReport")
+	}
+	if _, err := readAll(&Options{Checksums: ChecksumVerify}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatal("expected checksum mismatch with the deprecated name", err)
+	}
+	if _, err := readAll(&Options{Checksums: ChecksumIgnore}); err != nil {
+		t.Fatal(err)
+	}
+
+	cabFile, err = readAll(&Options{Checksums: ChecksumReport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading the block again must not report it twice
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	mismatches := cabFile.ChecksumMismatches()
+	if len(mismatches) != 1 {
+		t.Fatal("expected one mismatch, got", mismatches)
+	}
+	mismatch := mismatches[0]
+	if mismatch.Folder != 0 || mismatch.Block != 0 || mismatch.Offset != int64(dataOffset) || mismatch.Volume != -1 {
+		t.Fatal("unexpected mismatch", mismatch)
+	}
+	if mismatch.Expected != actual^0xFF || mismatch.Actual != actual {
+		t.Fatalf("unexpected checksums %08x and %08x", mismatch.Expected, mismatch.Actual)
+	}
+	if cabFile.folders[0].dataEntries[0].Checksum != mismatch.Expected {
+		t.Fatal("checksum of the data block was modified")
+	}
+}
+
+func TestChecksumsStreamReader(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataOffset := binary.LittleEndian.Uint32(testfileData[36:])
+	testfileData[dataOffset] ^= 0xFF
+
+	stream := NewStreamReaderWithOptions(bytes.NewReader(testfileData), &Options{Checksums: ChecksumReport})
+	for {
+		_, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(stream.ChecksumMismatches()) != 1 {
+		t.Fatal("expected one mismatch, got", stream.ChecksumMismatches())
+	}
+}
+
+func TestOpenWithOptionsLimits(t *testing.T) {
+	for _, test := range []struct {
+		file   string
+		limits Limits
+		err    error
+	}{
+		{"drivers.cab", Limits{MaxFiles: 1}, ErrTooManyFiles},
+		{"drivers.cab", Limits{MaxFolders: 0x7FFF, MaxDataBlocks: 1}, ErrTooManyDataBlocks},
+		{"drivers.cab", Limits{MaxUncompressedSize: 1000}, ErrUncompressedSizeLimit},
+		{"drivers.cab", Limits{MaxCompressionRatio: 1}, ErrCompressionRatioLimit},
+		{"drivers.cab", Limits{MaxNameLength: 3}, ErrNameTooLong},
+		{"lzx.cab", Limits{MaxWindowSize: 1 << 14}, ErrWindowSizeLimit},
+	} {
+		testfileData, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), &Options{Limits: test.limits})
+		if !errors.Is(err, test.err) || !errors.Is(err, ErrLimitExceeded) {
+			t.Fatal("expected limit to be exceeded", test.limits, err)
+		}
+		if _, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimitsStreamReader(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewStreamReaderWithOptions(bytes.NewReader(testfileData), &Options{Limits: Limits{MaxCompressionRatio: 1}})
+	if _, err := stream.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(stream); !errors.Is(err, ErrCompressionRatioLimit) {
+		t.Fatal("expected compression ratio limit to be exceeded", err)
+	}
+}
+
+func TestLimitsDeclaredSize(t *testing.T) {
+	content := testSetContent()
+	// The data block contains more data than it declares
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1000], 500}},
+		files:  []testFile{{"file.bin", 0, 1000, 0}},
+	})
+	cabFile, err := OpenWithOptions(bytes.NewReader(cabData), int64(len(cabData)), &Options{Limits: Limits{MaxUncompressedSize: 1 << 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrCorruptData) {
+		t.Fatal("expected error for data exceeding the declared size", err)
+	}
+}
+
+func TestOpenWithOptionsNameDecoder(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), &Options{
+		NameDecoder: func(name []byte) (string, error) {
+			return strings.ToUpper(string(name)), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cabFile.Files[0].Name != "TEST.YML" {
+		t.Fatal(cabFile.Files[0].Name)
+	}
+}
@@ -0,0 +1,136 @@
+package quantum
+
+// model is an adaptive frequency model for the arithmetic coder. Symbols are kept sorted by decreasing frequency
+// (approximately); symbols[entries] is a sentinel with a cumulative frequency of 0.
+type model struct {
+	shiftsLeft int
+	entries    int
+	symbols    []modelSymbol
+}
+
+type modelSymbol struct {
+	symbol              uint16
+	cumulativeFrequency uint16
+}
+
+func (m *model) init(start int, entries int) {
+	m.shiftsLeft = 4
+	m.entries = entries
+	m.symbols = make([]modelSymbol, entries+1)
+	for i := range m.symbols {
+		m.symbols[i] = modelSymbol{
+			symbol:              uint16(start + i),
+			cumulativeFrequency: uint16(entries - i),
+		}
+	}
+}
+
+// maxFrequency is the total frequency at which a model is rescaled.
+const maxFrequency = 3800
+
+// update increases the frequency of the symbol at the given index.
+func (m *model) update(index int) {
+	for i := index; i >= 0; i-- {
+		m.symbols[i].cumulativeFrequency += 8
+	}
+	if m.symbols[0].cumulativeFrequency > maxFrequency {
+		m.rescale()
+	}
+}
+
+// rescale halves the frequencies of the model. Every 50 rescales, the symbols are sorted by their frequency again.
+func (m *model) rescale() {
+	m.shiftsLeft--
+	if m.shiftsLeft != 0 {
+		for i := m.entries - 1; i >= 0; i-- {
+			m.symbols[i].cumulativeFrequency >>= 1
+			if m.symbols[i].cumulativeFrequency <= m.symbols[i+1].cumulativeFrequency {
+				m.symbols[i].cumulativeFrequency = m.symbols[i+1].cumulativeFrequency + 1
+			}
+		}
+		return
+	}
+	m.shiftsLeft = 50
+	// Convert cumulative frequencies to frequencies and halve them
+	for i := 0; i < m.entries; i++ {
+		m.symbols[i].cumulativeFrequency -= m.symbols[i+1].cumulativeFrequency
+		m.symbols[i].cumulativeFrequency++
+		m.symbols[i].cumulativeFrequency >>= 1
+	}
+	// Sort by decreasing frequency; this must be the same (unstable) sort that the reference implementation uses
+	for i := 0; i < m.entries-1; i++ {
+		for j := i + 1; j < m.entries; j++ {
+			if m.symbols[i].cumulativeFrequency < m.symbols[j].cumulativeFrequency {
+				m.symbols[i], m.symbols[j] = m.symbols[j], m.symbols[i]
+			}
+		}
+	}
+	// Convert back to cumulative frequencies
+	for i := m.entries - 1; i >= 0; i-- {
+		m.symbols[i].cumulativeFrequency += m.symbols[i+1].cumulativeFrequency
+	}
+}
+
+type models struct {
+	selector      model
+	literals      [4]model
+	match3        model // Position slots for matches of length 3
+	match4        model // Position slots for matches of length 4
+	matchPosition model // Position slots for longer matches
+	matchLength   model // Length slots for longer matches
+}
+
+func (m *models) init(windowBits int) {
+	positionSlots := windowBits * 2
+	m.selector.init(0, 7)
+	for i := range m.literals {
+		m.literals[i].init(i*64, 64)
+	}
+	m.match3.init(0, minInt(positionSlots, 24))
+	m.match4.init(0, minInt(positionSlots, 36))
+	m.matchPosition.init(0, positionSlots)
+	m.matchLength.init(0, 27)
+}
+
+// copyFrom sets the models to a copy of other.
+func (m *models) copyFrom(other *models) {
+	*m = *other
+	for _, model := range []*model{&m.selector, &m.literals[0], &m.literals[1], &m.literals[2], &m.literals[3], &m.match3,
+		&m.match4, &m.matchPosition, &m.matchLength} {
+		model.symbols = append([]modelSymbol(nil), model.symbols...)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var (
+	positionBase      [42]int
+	positionExtraBits [42]int
+	lengthBase        [27]int
+	lengthExtraBits   [27]int
+)
+
+func init() {
+	offset := 0
+	for i := range positionBase {
+		positionBase[i] = offset
+		if i >= 2 {
+			positionExtraBits[i] = (i - 2) >> 1
+		}
+		offset += 1 << positionExtraBits[i]
+	}
+	offset = 0
+	for i := 0; i < 26; i++ {
+		lengthBase[i] = offset
+		if i >= 2 {
+			lengthExtraBits[i] = (i - 2) >> 2
+		}
+		offset += 1 << lengthExtraBits[i]
+	}
+	lengthBase[26] = 254
+}
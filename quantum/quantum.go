@@ -0,0 +1,303 @@
+package quantum
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// New returns a reader that decompresses a Quantum compressed folder. Each block contains exactly one Quantum frame;
+// blockSizes contains the number of uncompressed bytes in each block. windowBits is the base-2 logarithm of the window
+// size, which must be between 10 and 21.
+func New(blocks []io.ReadCloser, blockSizes []int, windowBits int) (io.ReadCloser, error) {
+	return NewWithBlockSize(blocks, func(block int) (int, error) {
+		if block >= len(blockSizes) {
+			return 0, errors.New("missing Quantum block size")
+		}
+		return blockSizes[block], nil
+	}, windowBits)
+}
+
+// NewWithBlockSize is like New, but the uncompressed block sizes are not known in advance. blockSize returns the
+// number of uncompressed bytes in the block with the given index. It is called once per block, before the block is
+// decompressed.
+func NewWithBlockSize(blocks []io.ReadCloser, blockSize func(block int) (int, error), windowBits int) (io.ReadCloser, error) {
+	if windowBits < 10 || windowBits > 21 {
+		return nil, errors.New("invalid Quantum window size")
+	}
+	reader := &quantumReader{
+		blocks:    blocks,
+		blockSize: blockSize,
+		window:    make([]byte, 1<<windowBits),
+	}
+	reader.models.init(windowBits)
+	return reader, nil
+}
+
+// State is the state of a Quantum decompressor between two blocks, from which decompression can be resumed.
+type State struct {
+	window         []byte
+	windowPosition int
+	models         models
+}
+
+// Checkpoint returns the state of a decompressor returned by New, NewWithBlockSize or Resume after the blocks that
+// were decompressed so far. It fails if not all of their data has been read.
+func Checkpoint(decompressor io.Reader) (*State, error) {
+	q, ok := decompressor.(*quantumReader)
+	if !ok {
+		return nil, errors.New("not a Quantum decompressor")
+	}
+	if len(q.frame) > 0 {
+		return nil, errors.New("Quantum decompressor is not at the end of a block")
+	}
+	state := &State{
+		window:         append([]byte(nil), q.window...),
+		windowPosition: q.windowPosition,
+	}
+	state.models.copyFrom(&q.models)
+	return state, nil
+}
+
+// Resume returns a reader that continues decompressing a Quantum compressed folder from the given state. blocks
+// contains the blocks following the state, and blockSize returns their uncompressed sizes like for
+// NewWithBlockSize. The state is not modified, so that it can be resumed several times.
+func Resume(state *State, blocks []io.ReadCloser, blockSize func(block int) (int, error)) io.ReadCloser {
+	reader := &quantumReader{
+		blocks:         blocks,
+		blockSize:      blockSize,
+		window:         append([]byte(nil), state.window...),
+		windowPosition: state.windowPosition,
+	}
+	reader.models.copyFrom(&state.models)
+	return reader
+}
+
+const frameSize = 1 << 15
+
+type quantumReader struct {
+	blocks     []io.ReadCloser
+	blockSize  func(block int) (int, error)
+	blockIndex int
+
+	window         []byte
+	windowPosition int
+
+	frameBuffer []byte
+	frame       []byte // Decoded, but not yet returned part of frameBuffer
+
+	models models
+	bits   bitReader
+
+	// State of the arithmetic decoder
+	high, low, code uint16
+}
+
+func (q *quantumReader) Read(b []byte) (n int, err error) {
+	for len(q.frame) == 0 {
+		if len(q.blocks) == 0 {
+			return 0, io.EOF
+		}
+		if err := q.decodeFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(b, q.frame)
+	q.frame = q.frame[n:]
+	return n, nil
+}
+
+func (q *quantumReader) Close() (err error) {
+	for _, reader := range q.blocks {
+		if readerErr := reader.Close(); readerErr != nil {
+			if err == nil {
+				err = readerErr
+			}
+		}
+	}
+	q.blocks = nil
+	q.frame = nil
+	return
+}
+
+// decodeFrame decodes the next block and closes it.
+func (q *quantumReader) decodeFrame() error {
+	block := q.blocks[0]
+	size, err := q.blockSize(q.blockIndex)
+	if err != nil {
+		return err
+	}
+	if size > frameSize {
+		return errors.New("Quantum block is larger than a frame")
+	}
+	if err := q.decodeFrameData(block, size); err != nil {
+		return err
+	}
+	q.blocks = q.blocks[1:]
+	q.blockIndex++
+	return block.Close()
+}
+
+func (q *quantumReader) decodeFrameData(block io.Reader, size int) error {
+	q.bits.reset(block)
+	initialCode, err := q.bits.readBits(16)
+	if err != nil {
+		return err
+	}
+	q.high, q.low, q.code = 0xFFFF, 0, uint16(initialCode)
+
+	q.frameBuffer = q.frameBuffer[:0]
+	for len(q.frameBuffer) < size {
+		selector, err := q.decodeSymbol(&q.models.selector)
+		if err != nil {
+			return err
+		}
+		if selector < 4 {
+			literal, err := q.decodeSymbol(&q.models.literals[selector])
+			if err != nil {
+				return err
+			}
+			q.output(byte(literal))
+			continue
+		}
+		var matchLength int
+		var positionModel *model
+		switch selector {
+		case 4:
+			matchLength = 3
+			positionModel = &q.models.match3
+		case 5:
+			matchLength = 4
+			positionModel = &q.models.match4
+		case 6:
+			lengthSlot, err := q.decodeSymbol(&q.models.matchLength)
+			if err != nil {
+				return err
+			}
+			extra, err := q.bits.readBits(lengthExtraBits[lengthSlot])
+			if err != nil {
+				return err
+			}
+			matchLength = lengthBase[lengthSlot] + int(extra) + 5
+			positionModel = &q.models.matchPosition
+		default:
+			return errors.New("invalid Quantum selector")
+		}
+		positionSlot, err := q.decodeSymbol(positionModel)
+		if err != nil {
+			return err
+		}
+		extra, err := q.bits.readBits(positionExtraBits[positionSlot])
+		if err != nil {
+			return err
+		}
+		matchOffset := positionBase[positionSlot] + int(extra) + 1
+		if matchOffset > len(q.window) {
+			return errors.New("Quantum match offset is beyond window")
+		}
+		for i := 0; i < matchLength; i++ {
+			q.output(q.window[(q.windowPosition-matchOffset+len(q.window))%len(q.window)])
+		}
+	}
+	if len(q.frameBuffer) > size {
+		if size == frameSize {
+			return errors.New("Quantum match exceeds frame")
+		}
+		// The last frame of a folder ends after the requested bytes
+		q.frameBuffer = q.frameBuffer[:size]
+	}
+	q.frame = q.frameBuffer
+	return nil
+}
+
+func (q *quantumReader) output(b byte) {
+	q.window[q.windowPosition] = b
+	q.windowPosition = (q.windowPosition + 1) % len(q.window)
+	q.frameBuffer = append(q.frameBuffer, b)
+}
+
+// decodeSymbol decodes the next symbol using the given model and updates the model afterwards.
+func (q *quantumReader) decodeSymbol(m *model) (int, error) {
+	symbolRange := uint32(q.high-q.low) + 1
+	target := ((uint32(q.code-q.low)+1)*uint32(m.symbols[0].cumulativeFrequency) - 1) / symbolRange & 0xFFFF
+
+	i := 1
+	for ; i < m.entries; i++ {
+		if uint32(m.symbols[i].cumulativeFrequency) <= target {
+			break
+		}
+	}
+	symbol := int(m.symbols[i-1].symbol)
+
+	total := uint32(m.symbols[0].cumulativeFrequency)
+	q.high = q.low + uint16(uint32(m.symbols[i-1].cumulativeFrequency)*symbolRange/total) - 1
+	q.low = q.low + uint16(uint32(m.symbols[i].cumulativeFrequency)*symbolRange/total)
+	m.update(i - 1)
+
+	for {
+		if q.low&0x8000 != q.high&0x8000 {
+			if q.low&0x4000 != 0 && q.high&0x4000 == 0 {
+				// Underflow
+				q.code ^= 0x4000
+				q.low &= 0x3FFF
+				q.high |= 0x4000
+			} else {
+				break
+			}
+		}
+		q.low <<= 1
+		q.high = q.high<<1 | 1
+		bit, err := q.bits.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		q.code = q.code<<1 | uint16(bit)
+	}
+	return symbol, nil
+}
+
+// maxPadding is the number of zero bytes that may be read after the end of a block.
+const maxPadding = 4
+
+// bitReader reads bits, most significant bit first, from a block. After the end of the block, it returns the trailer
+// byte 0xFF, followed by a few bytes of zero padding.
+type bitReader struct {
+	reader     *bufio.Reader
+	buffer     uint64
+	bufferBits int
+	padding    int
+}
+
+func (b *bitReader) reset(block io.Reader) {
+	if b.reader == nil {
+		b.reader = bufio.NewReader(block)
+	} else {
+		b.reader.Reset(block)
+	}
+	b.buffer = 0
+	b.bufferBits = 0
+	b.padding = -1
+}
+
+func (b *bitReader) readBits(count int) (uint32, error) {
+	for b.bufferBits < count {
+		nextByte, err := b.reader.ReadByte()
+		if err == io.EOF {
+			if b.padding == maxPadding {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if b.padding < 0 {
+				nextByte = 0xFF
+			}
+			b.padding++
+		} else if err != nil {
+			return 0, err
+		}
+		b.buffer = b.buffer<<8 | uint64(nextByte)
+		b.bufferBits += 8
+	}
+	b.bufferBits -= count
+	value := uint32(b.buffer>>b.bufferBits) & (1<<count - 1)
+	b.buffer &= 1<<b.bufferBits - 1
+	return value, nil
+}
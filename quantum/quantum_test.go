@@ -0,0 +1,311 @@
+package quantum
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testEncoder is a simple Quantum compressor that is used to create test data for the decompressor.
+type testEncoder struct {
+	models     models
+	windowBits int
+	history    []byte
+}
+
+type rawBits struct {
+	position int // Index of the arithmetic code bit before which the raw bits are inserted
+	value    uint32
+	count    int
+}
+
+// frameEncoder encodes a single frame with the arithmetic coder.
+type frameEncoder struct {
+	high, low uint16
+	pending   int
+	shifts    int
+	code      []bool
+	raw       []rawBits
+}
+
+func (f *frameEncoder) emit(bit bool) {
+	f.code = append(f.code, bit)
+	for ; f.pending > 0; f.pending-- {
+		f.code = append(f.code, !bit)
+	}
+}
+
+func (f *frameEncoder) encodeSymbol(m *model, symbol int) {
+	index := -1
+	for i := 0; i < m.entries; i++ {
+		if int(m.symbols[i].symbol) == symbol {
+			index = i
+		}
+	}
+	if index < 0 {
+		panic("symbol not in model")
+	}
+	symbolRange := uint32(f.high-f.low) + 1
+	total := uint32(m.symbols[0].cumulativeFrequency)
+	f.high = f.low + uint16(uint32(m.symbols[index].cumulativeFrequency)*symbolRange/total) - 1
+	f.low = f.low + uint16(uint32(m.symbols[index+1].cumulativeFrequency)*symbolRange/total)
+	m.update(index)
+	for {
+		if f.low&0x8000 != f.high&0x8000 {
+			if f.low&0x4000 != 0 && f.high&0x4000 == 0 {
+				f.pending++
+				f.low &= 0x3FFF
+				f.high |= 0x4000
+			} else {
+				break
+			}
+		} else {
+			f.emit(f.high&0x8000 != 0)
+		}
+		f.low <<= 1
+		f.high = f.high<<1 | 1
+		f.shifts++
+	}
+}
+
+func (f *frameEncoder) writeRaw(value int, count int) {
+	if count > 0 {
+		f.raw = append(f.raw, rawBits{16 + f.shifts, uint32(value), count})
+	}
+}
+
+func (f *frameEncoder) finish() []byte {
+	f.pending++
+	f.emit(f.low >= 0x4000)
+	for len(f.code) < 16+f.shifts {
+		f.code = append(f.code, false)
+	}
+	var bits []bool
+	raw := f.raw
+	for i := 0; i <= len(f.code); i++ {
+		for len(raw) > 0 && raw[0].position == i {
+			for bit := raw[0].count - 1; bit >= 0; bit-- {
+				bits = append(bits, raw[0].value&(1<<bit) != 0)
+			}
+			raw = raw[1:]
+		}
+		if i < len(f.code) {
+			bits = append(bits, f.code[i])
+		}
+	}
+	var data = make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return data
+}
+
+func slotFor(base []int, extraBits []int, value int) int {
+	slot := 0
+	for slot+1 < len(base) && base[slot+1] <= value {
+		slot++
+	}
+	if value-base[slot] >= 1<<extraBits[slot] {
+		panic("value out of range")
+	}
+	return slot
+}
+
+// compressFrame compresses a frame using greedy matching against the data compressed so far.
+func (e *testEncoder) compressFrame(frame []byte) []byte {
+	f := frameEncoder{high: 0xFFFF}
+	windowSize := 1 << e.windowBits
+	for position := 0; position < len(frame); {
+		bestLength, bestOffset := 0, 0
+		maxLength := len(frame) - position
+		if maxLength > 259 {
+			maxLength = 259
+		}
+		current := len(e.history)
+		for offset := 1; offset <= windowSize && offset <= current && offset <= 4096; offset++ {
+			length := 0
+			for length < maxLength && e.history[current-offset+length%offset] == frame[position+length] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestOffset = length, offset
+			}
+		}
+		if bestLength == 3 && slotFor(positionBase[:], positionExtraBits[:], bestOffset-1) >= e.models.match3.entries {
+			bestLength = 0
+		}
+		if bestLength == 4 && slotFor(positionBase[:], positionExtraBits[:], bestOffset-1) >= e.models.match4.entries {
+			bestLength = 3
+		}
+		switch {
+		case bestLength < 3:
+			literal := int(frame[position])
+			f.encodeSymbol(&e.models.selector, literal>>6)
+			f.encodeSymbol(&e.models.literals[literal>>6], literal)
+			bestLength = 1
+		case bestLength == 3 || bestLength == 4:
+			positionModel := &e.models.match3
+			if bestLength == 4 {
+				positionModel = &e.models.match4
+			}
+			f.encodeSymbol(&e.models.selector, bestLength+1)
+			slot := slotFor(positionBase[:], positionExtraBits[:], bestOffset-1)
+			f.encodeSymbol(positionModel, slot)
+			f.writeRaw(bestOffset-1-positionBase[slot], positionExtraBits[slot])
+		default:
+			f.encodeSymbol(&e.models.selector, 6)
+			lengthSlot := slotFor(lengthBase[:], lengthExtraBits[:], bestLength-5)
+			f.encodeSymbol(&e.models.matchLength, lengthSlot)
+			f.writeRaw(bestLength-5-lengthBase[lengthSlot], lengthExtraBits[lengthSlot])
+			slot := slotFor(positionBase[:], positionExtraBits[:], bestOffset-1)
+			f.encodeSymbol(&e.models.matchPosition, slot)
+			f.writeRaw(bestOffset-1-positionBase[slot], positionExtraBits[slot])
+		}
+		e.history = append(e.history, frame[position:position+bestLength]...)
+		position += bestLength
+	}
+	return f.finish()
+}
+
+func compress(data []byte, windowBits int) (blocks []io.ReadCloser, blockSizes []int) {
+	encoder := &testEncoder{windowBits: windowBits}
+	encoder.models.init(windowBits)
+	for len(data) > 0 {
+		frame := data
+		if len(frame) > frameSize {
+			frame = frame[:frameSize]
+		}
+		data = data[len(frame):]
+		blocks = append(blocks, io.NopCloser(bytes.NewReader(encoder.compressFrame(frame))))
+		blockSizes = append(blockSizes, len(frame))
+	}
+	return blocks, blockSizes
+}
+
+func testData() []byte {
+	random := rand.New(rand.NewSource(1))
+	var data []byte
+	words := []string{"cabinet", "folder", "quantum", "compression", "data", " ", "\n", "file"}
+	for len(data) < 100000 {
+		if random.Intn(10) == 0 {
+			data = append(data, byte(random.Intn(256)))
+		} else {
+			data = append(data, words[random.Intn(len(words))]...)
+		}
+	}
+	return data
+}
+
+func TestRoundtrip(t *testing.T) {
+	data := testData()
+	for _, windowBits := range []int{10, 15, 21} {
+		blocks, blockSizes := compress(data, windowBits)
+		reader, err := New(blocks, blockSizes, windowBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatal("decompressed data differs for window size", windowBits)
+		}
+	}
+}
+
+func TestNewWithBlockSize(t *testing.T) {
+	data := testData()
+	blocks, blockSizes := compress(data, 16)
+	var calls []int
+	reader, err := NewWithBlockSize(blocks, func(block int) (int, error) {
+		calls = append(calls, block)
+		return blockSizes[block], nil
+	}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, data) || len(calls) != len(blocks) {
+		t.Fatal("unexpected result, block sizes requested for", calls)
+	}
+
+	// Errors of blockSize are returned
+	blocks, _ = compress(data, 16)
+	reader, err = NewWithBlockSize(blocks, func(block int) (int, error) {
+		return 0, errors.New("unknown size")
+	}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Fatal("expected error of blockSize")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	data := testData()
+	blocks, blockSizes := compress(data, 15)
+	reader, err := New(blocks, blockSizes, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(reader, make([]byte, blockSizes[0]+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Checkpoint(reader); err == nil {
+		t.Fatal("expected error for decompressor within a block")
+	}
+	if _, err := io.ReadFull(reader, make([]byte, blockSizes[1]-1)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := Checkpoint(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := blockSizes[0] + blockSizes[1]
+	// Resuming does not modify the state, so it works more than once
+	for i := 0; i < 2; i++ {
+		resumedBlocks, _ := compress(data, 15)
+		resumed := Resume(state, resumedBlocks[2:], func(block int) (int, error) {
+			return blockSizes[block+2], nil
+		})
+		decompressed, err := io.ReadAll(resumed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, data[start:]) {
+			t.Fatal("resumed data differs")
+		}
+	}
+	if _, err := Checkpoint(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error for other reader")
+	}
+}
+
+func TestInvalidWindowSize(t *testing.T) {
+	if _, err := New(nil, nil, 22); err == nil {
+		t.Fatal("expected error for window size")
+	}
+}
+
+func TestTruncatedBlock(t *testing.T) {
+	data := testData()
+	blocks, blockSizes := compress(data, 16)
+	blocks[1] = io.NopCloser(io.LimitReader(blocks[1], 100))
+	reader, err := New(blocks, blockSizes, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err == nil && bytes.Equal(decompressed, data) {
+		t.Fatal("expected truncated block to be detected")
+	}
+}
@@ -0,0 +1,276 @@
+package cab
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/secDre4mer/go-cab/quantum"
+)
+
+// FileReader provides random access to the contents of a file via io.Reader, io.ReaderAt and io.Seeker.
+// It must be closed after use to release the decompressors it holds.
+type FileReader struct {
+	*io.SectionReader
+	folder *folderReaderAt
+}
+
+// OpenReaderAt opens the file for random access.
+//
+// Uncompressed folders are read directly from the data blocks containing the requested data. MSZIP compressed
+// folders can restart decompression at every eighth data block once the preceding data has been decompressed, and
+// Quantum compressed folders likewise every eighth block, or every 2 window sizes of data if that is longer, since
+// each restart point holds a copy of the window. Additionally, a few decompressors are kept at the positions where
+// the last reads ended.
+//
+// An MSZIP restart point is the uncompressed 32 KiB block before it, which the next block uses as its dictionary.
+// The reader keeps only the most recently read block, so each restart point is an additional copy of 32 KiB; with a
+// restart point at every block, the reader would eventually hold the whole uncompressed folder, up to 2 GiB. Every
+// eighth block limits the memory to an eighth of the data that was read, while a read re-decodes at most 7 blocks,
+// or 224 KiB of data.
+//
+// The LZX decompressor cannot save its state, so for LZX compressed folders, reading before all positions of the
+// kept decompressors requires decompressing the folder from its beginning again.
+func (f *File) OpenReaderAt() (*FileReader, error) {
+	folder, err := newFolderReaderAt(f.folder)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{
+		SectionReader: io.NewSectionReader(folder, int64(f.header.UncompressedOffsetInFolder), int64(f.header.UncompressedFileSize)),
+		folder:        folder,
+	}, nil
+}
+
+func (r *FileReader) Close() error {
+	return r.folder.Close()
+}
+
+const (
+	// checkpointInterval is the minimum number of blocks between two stored checkpoints, see OpenReaderAt
+	checkpointInterval = 8
+	// maxParkedDecoders is the number of decompressors that are kept for later reads
+	maxParkedDecoders = 4
+)
+
+// folderReaderAt provides random access to the uncompressed data of a folder.
+type folderReaderAt struct {
+	mutex sync.Mutex
+
+	folder          *cabinetFileFolder
+	compressionType uint16
+	blockStarts     []int64 // Uncompressed offset of each block, followed by the size of the folder
+
+	// Decompressors that are positioned at the start of a block, least recently used first
+	decoders []*blockDecoder
+	// Blocks where decompression can restart, for MSZIP and Quantum compressed folders
+	checkpoints        map[int]*checkpoint
+	checkpointInterval int
+
+	cachedIndex int
+	cachedBlock []byte
+	closed      bool
+}
+
+type blockDecoder struct {
+	reader    io.ReadCloser
+	nextBlock int
+}
+
+func newFolderReaderAt(folder *cabinetFileFolder) (*folderReaderAt, error) {
+	if folder.streamed {
+		return nil, ErrStreamedFile
+	}
+	if folder.continuedFromPrevious {
+		return nil, folderError(folder.index, -1, ErrMissingVolume)
+	}
+	reader := &folderReaderAt{
+		folder:             folder,
+		compressionType:    folder.CompressionType & compressionTypeMask,
+		blockStarts:        make([]int64, len(folder.dataEntries)+1),
+		checkpoints:        map[int]*checkpoint{},
+		checkpointInterval: checkpointInterval,
+		cachedIndex:        -1,
+	}
+	if reader.compressionType == compressionTypeQuantum {
+		// Each checkpoint holds a copy of the window, so keep them at least 2 window sizes of data apart; every
+		// block but the last contains 32 KiB of data
+		windowSize := 1 << int((folder.CompressionType>>8)&0x1F)
+		if blocks := 2 * windowSize / (32 * 1024); blocks > reader.checkpointInterval {
+			reader.checkpointInterval = blocks
+		}
+	}
+	for i := range folder.dataEntries {
+		reader.blockStarts[i+1] = reader.blockStarts[i] + int64(folder.dataEntries[i].uncompressedSize())
+	}
+	return reader, nil
+}
+
+func (r *folderReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, errors.New("reader is closed")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	for len(p) > 0 {
+		blockIndex := sort.Search(len(r.blockStarts)-1, func(i int) bool {
+			return r.blockStarts[i+1] > offset
+		})
+		if blockIndex == len(r.blockStarts)-1 {
+			return n, io.EOF
+		}
+		block, err := r.block(blockIndex)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p, block[offset-r.blockStarts[blockIndex]:])
+		p = p[copied:]
+		n += copied
+		offset += int64(copied)
+	}
+	return n, nil
+}
+
+// block returns the uncompressed data of a block.
+func (r *folderReaderAt) block(index int) ([]byte, error) {
+	if r.cachedIndex == index {
+		return r.cachedBlock, nil
+	}
+	var data []byte
+	var err error
+	if r.compressionType == compressionTypeNone {
+		data, err = r.readStoredBlock(index)
+	} else {
+		data, err = r.decodeBlock(index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.cachedIndex, r.cachedBlock = index, data
+	return data, nil
+}
+
+// readStoredBlock reads a block of an uncompressed folder directly from its data block.
+func (r *folderReaderAt) readStoredBlock(index int) ([]byte, error) {
+	reader, err := openFileData(&r.folder.dataEntries[index], r.folder.options)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.readBlockData(reader, index)
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	return data, err
+}
+
+// decodeBlock decompresses a block, using the closest decompressor or restart point before it.
+func (r *folderReaderAt) decodeBlock(index int) ([]byte, error) {
+	decoder, err := r.decoderFor(index)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		data, err := r.readBlockData(decoder.reader, decoder.nextBlock)
+		if err != nil {
+			decoder.reader.Close()
+			return nil, err
+		}
+		decoder.nextBlock++
+		if decoder.nextBlock%r.checkpointInterval == 0 && decoder.nextBlock < len(r.folder.dataEntries) &&
+			r.checkpoints[decoder.nextBlock] == nil {
+			r.saveCheckpoint(decoder, data)
+		}
+		if decoder.nextBlock > index {
+			r.park(decoder)
+			return data, nil
+		}
+	}
+}
+
+func (r *folderReaderAt) readBlockData(reader io.Reader, index int) ([]byte, error) {
+	data := make([]byte, r.blockStarts[index+1]-r.blockStarts[index])
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// saveCheckpoint stores a checkpoint for decoder.nextBlock. data is the uncompressed data of the preceding block.
+func (r *folderReaderAt) saveCheckpoint(decoder *blockDecoder, data []byte) {
+	switch r.compressionType {
+	case compressionTypeMszip:
+		r.checkpoints[decoder.nextBlock] = &checkpoint{dictionary: data}
+	case compressionTypeQuantum:
+		// Decompressors opened by openAt are always folderDataReaders
+		state, err := quantum.Checkpoint(decoder.reader.(*folderDataReader).ReadCloser)
+		if err == nil {
+			r.checkpoints[decoder.nextBlock] = &checkpoint{quantum: state}
+		}
+	}
+}
+
+// decoderFor returns a decompressor positioned at or before the given block. It is removed from the parked
+// decompressors.
+func (r *folderReaderAt) decoderFor(index int) (*blockDecoder, error) {
+	restartBlock := 0
+	for block := range r.checkpoints {
+		if block <= index && block > restartBlock {
+			restartBlock = block
+		}
+	}
+	best := -1
+	for i, decoder := range r.decoders {
+		if decoder.nextBlock <= index && decoder.nextBlock >= restartBlock && (best < 0 || decoder.nextBlock > r.decoders[best].nextBlock) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		decoder := r.decoders[best]
+		r.decoders = append(r.decoders[:best], r.decoders[best+1:]...)
+		return decoder, nil
+	}
+	reader, err := r.folder.openAt(context.Background(), restartBlock, len(r.folder.dataEntries), r.checkpoints[restartBlock])
+	if err != nil {
+		return nil, err
+	}
+	return &blockDecoder{reader: reader, nextBlock: restartBlock}, nil
+}
+
+// park stores a decompressor for later use, closing the least recently used one if there are too many.
+func (r *folderReaderAt) park(decoder *blockDecoder) {
+	if decoder.nextBlock == len(r.folder.dataEntries) {
+		// Nothing left to decompress
+		decoder.reader.Close()
+		return
+	}
+	r.decoders = append(r.decoders, decoder)
+	if len(r.decoders) > maxParkedDecoders {
+		r.decoders[0].reader.Close()
+		r.decoders = r.decoders[1:]
+	}
+}
+
+func (r *folderReaderAt) Close() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, decoder := range r.decoders {
+		if closeErr := decoder.reader.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	r.decoders = nil
+	r.checkpoints = nil
+	r.cachedBlock = nil
+	r.closed = true
+	return
+}
@@ -0,0 +1,190 @@
+package cab
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	for _, testfile := range []string{"testdata/drivers.cab", "testdata/lzx.cab", "testdata/large.cab", "testdata/quantum.cab"} {
+		testfileData, err := os.ReadFile(testfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only test a few files to keep the test fast
+		for i, file := range cabFile.Files {
+			if i >= 2 {
+				break
+			}
+			testRandomAccess(t, file)
+		}
+	}
+}
+
+func TestOpenReaderAtStored(t *testing.T) {
+	content := testSetContent()
+	volume := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1500], 1500}, {content[1500:3000], 1500}, {content[3000:], 500}},
+		files: []testFile{
+			{"first.bin", 0, 1000, 0},
+			{"second.bin", 1000, 2500, 0},
+		},
+	})
+	cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range cabFile.Files {
+		testRandomAccess(t, file)
+	}
+}
+
+func testRandomAccess(t *testing.T, file *File) {
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileReader, err := file.OpenReaderAt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileReader.Close()
+	if fileReader.Size() != int64(len(expected)) {
+		t.Fatal("size mismatch for", file.Name)
+	}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		offset := random.Int63n(int64(len(expected)))
+		length := random.Intn(100000)
+		if offset+int64(length) > int64(len(expected)) {
+			length = int(int64(len(expected)) - offset)
+		}
+		buffer := make([]byte, length)
+		if _, err := fileReader.ReadAt(buffer, offset); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buffer, expected[offset:offset+int64(length)]) {
+			t.Fatal("content mismatch for", file.Name, "at offset", offset)
+		}
+	}
+
+	// Seek to the end and read backwards
+	if _, err := fileReader.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(fileReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, expected[len(expected)-len(tail):]) {
+		t.Fatal("content mismatch at end of", file.Name)
+	}
+}
+
+func TestReaderAtCheckpoints(t *testing.T) {
+	for _, name := range []string{"drivers.cab", "quantum.cab"} {
+		testfileData, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		folder := cabFile.Files[0].folder
+		expected, err := folder.open(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := newFolderReaderAt(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.checkpointInterval = 1
+		// Reading the whole folder stores a checkpoint at every block
+		if _, err := reader.ReadAt(make([]byte, len(data)), 0); err != nil {
+			t.Fatal(name, err)
+		}
+		if len(reader.checkpoints) != len(folder.dataEntries)-1 {
+			t.Fatal(name, "unexpected number of checkpoints", len(reader.checkpoints))
+		}
+		// Without parked decompressors, reading backwards restarts at the checkpoints
+		for _, decoder := range reader.decoders {
+			decoder.reader.Close()
+		}
+		reader.decoders = nil
+		for block := len(folder.dataEntries) - 1; block >= 0; block-- {
+			start, end := reader.blockStarts[block], reader.blockStarts[block+1]
+			buffer := make([]byte, end-start)
+			if _, err := reader.ReadAt(buffer, start); err != nil {
+				t.Fatal(name, err)
+			}
+			if !bytes.Equal(buffer, data[start:end]) {
+				t.Fatal(name, "content mismatch in block", block)
+			}
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadAtBackward(b *testing.B) {
+	for _, name := range []string{"drivers.cab", "lzx.cab", "quantum.cab"} {
+		b.Run(name, func(b *testing.B) {
+			testfileData, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			var file *File
+			for _, candidate := range cabFile.Files {
+				if file == nil || candidate.header.UncompressedFileSize > file.header.UncompressedFileSize {
+					file = candidate
+				}
+			}
+			buffer := make([]byte, 4096)
+			b.SetBytes(int64(file.header.UncompressedFileSize))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader, err := file.OpenReaderAt()
+				if err != nil {
+					b.Fatal(err)
+				}
+				for offset := reader.Size() - int64(len(buffer)); offset > -int64(len(buffer)); offset -= int64(len(buffer)) {
+					if offset < 0 {
+						offset = 0
+					}
+					if _, err := reader.ReadAt(buffer, offset); err != nil && err != io.EOF {
+						b.Fatal(err)
+					}
+					if offset == 0 {
+						break
+					}
+				}
+				reader.Close()
+			}
+		})
+	}
+}
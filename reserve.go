@@ -0,0 +1,178 @@
+package cab
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Reserve contains the sizes of the reserved areas of a cabinet, in which applications store their own data, e.g.
+// code signatures. Header is the size of the area after CFHEADER (cbCFHeader), see Cabinet.ReservedHeaderBlock;
+// Folder and Data are the sizes of the areas in every CFFOLDER and CFDATA entry (cbCFFolder and cbCFData).
+type Reserve struct {
+	Header int
+	Folder int
+	Data   int
+}
+
+const (
+	// AuthenticodeHeaderReserve is the size of the reserved header area that signtool and osslsigncode expect in
+	// cabinets they sign. They store the location of the signature there, which is appended to the cabinet.
+	AuthenticodeHeaderReserve = 20
+
+	// maxHeaderReserve is the maximum size of the reserved header area.
+	maxHeaderReserve = 60000
+)
+
+func (r Reserve) validate() error {
+	if r.Header < 0 || r.Header > maxHeaderReserve {
+		return fmt.Errorf("invalid reserved header size %d", r.Header)
+	}
+	if r.Folder < 0 || r.Folder > 0xFF || r.Data < 0 || r.Data > 0xFF {
+		return fmt.Errorf("invalid reserved folder or data size %d, %d", r.Folder, r.Data)
+	}
+	return nil
+}
+
+// headerSize returns the size of the reserved sizes and the reserved header area, which follow CFHEADER if any
+// area is reserved.
+func (r Reserve) headerSize() int64 {
+	if r == (Reserve{}) {
+		return 0
+	}
+	return int64(binary.Size(cabinetFileReservedSizes{}) + r.Header)
+}
+
+// ReservedFolderArea returns the reserved area of the CFFOLDER entry of the file's folder, whose size is
+// Cabinet.Reserve.Folder. The returned slice must not be modified.
+func (f *File) ReservedFolderArea() []byte {
+	return f.folder.reservedData
+}
+
+// ReservedDataAreas returns the reserved areas of the CFDATA blocks of the file's folder, in order, whose size is
+// Cabinet.Reserve.Data. A data block that is split across the cabinets of a set has one area per part. The returned
+// slices must not be modified.
+func (f *File) ReservedDataAreas() [][]byte {
+	var areas [][]byte
+	for i := range f.folder.dataEntries {
+		for entry := &f.folder.dataEntries[i]; entry != nil; entry = entry.next {
+			areas = append(areas, entry.reservedData)
+		}
+	}
+	return areas
+}
+
+// PatchReservedHeader overwrites the reserved header area of a cabinet in place, e.g. to store a signature after the
+// cabinet was written. The other structures of the cabinet are not changed, and no checksum covers the area. data
+// must have the size of the area, which cannot change in place; for cabinets without a reserved header area, like
+// most existing unsigned cabinets, or with an area of a different size, it returns ErrReservedHeaderSize. Use
+// AddReservedHeader to write a copy of such a cabinet, or Writer.SetReserve to create cabinets with the area.
+func PatchReservedHeader(cabinet interface {
+	io.ReaderAt
+	io.WriterAt
+}, data []byte) error {
+	var header cabinetFileHeader
+	var sizes cabinetFileReservedSizes
+	reader := io.NewSectionReader(cabinet, 0, int64(binary.Size(header)+binary.Size(sizes)))
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return headerError(0, err)
+	}
+	if header.Signature != [4]byte{0x4D, 0x53, 0x43, 0x46} {
+		return headerError(0, ErrInvalidSignature)
+	}
+	if header.Flags&cabinetReserveExists == 0 {
+		return fmt.Errorf("%w: cabinet has no reserved areas", ErrReservedHeaderSize)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &sizes); err != nil {
+		return headerError(int64(binary.Size(header)), err)
+	}
+	if len(data) != int(sizes.ReservedHeaderSize) {
+		return fmt.Errorf("%w: area has %d bytes, not %d", ErrReservedHeaderSize, sizes.ReservedHeaderSize, len(data))
+	}
+	_, err := cabinet.WriteAt(data, int64(binary.Size(header)+binary.Size(sizes)))
+	return err
+}
+
+// AddReservedHeader writes a copy of a cabinet to output, whose reserved header area contains data. This prepares an
+// existing cabinet for PatchReservedHeader or a signing tool if it has no reserved header area, or one of a different
+// size. The CFHEADER offsets and the data block offsets of the CFFOLDER entries are shifted accordingly; everything
+// else, including the reserved areas of folders and data blocks and the checksums, is copied unchanged. Data after
+// the end of the cabinet, such as an appended signature, is not copied, since the rewritten cabinet invalidates it.
+// Each cabinet of a multi-cabinet set is rewritten on its own.
+func AddReservedHeader(output io.Writer, cabinet io.ReaderAt, data []byte) error {
+	if len(data) > maxHeaderReserve {
+		return fmt.Errorf("invalid reserved header size %d", len(data))
+	}
+	var cab Cabinet
+	options := (*Options)(nil).normalized()
+	source := &streamSource{reader: io.NewSectionReader(cabinet, 0, math.MaxInt64)}
+	header, sizes, err := cab.readHeader(source, &options)
+	if err != nil {
+		return err
+	}
+	namesOffset := int64(binary.Size(header)) + cab.Reserve.headerSize()
+	foldersOffset := source.position
+	folders, err := readFolderEntries(context.Background(), source, header.FolderCount, sizes.ReservedFolderSize)
+	if err != nil {
+		return err
+	}
+	// Everything that an offset refers to must follow the CFFOLDER entries, since only they are rewritten
+	if int64(header.FirstFileEntryOffset) < source.position || int64(header.Filesize) < source.position {
+		return headerError(0, fmt.Errorf("%w: CFFILE entries or the end of the cabinet precede the CFFOLDER entries", ErrInvalidLayout))
+	}
+	for i, folder := range folders {
+		if folder.CfDataCount > 0 && int64(folder.CoffCabStart) < source.position {
+			return folderError(i, foldersOffset, fmt.Errorf("%w: data blocks precede the CFFOLDER entries", ErrInvalidLayout))
+		}
+	}
+
+	end := int64(header.Filesize)
+	if n, _ := cabinet.ReadAt(make([]byte, 1), end-1); n != 1 {
+		return headerError(0, ErrTruncated)
+	}
+
+	reserve := cab.Reserve
+	reserve.Header = len(data)
+	shift := reserve.headerSize() - cab.Reserve.headerSize()
+	if int64(header.Filesize)+shift > 0xFFFFFFFF {
+		return errors.New("cabinet is too large")
+	}
+	header.Filesize = uint32(int64(header.Filesize) + shift)
+	header.FirstFileEntryOffset = uint32(int64(header.FirstFileEntryOffset) + shift)
+	header.Flags &^= cabinetReserveExists
+	if reserve != (Reserve{}) {
+		header.Flags |= cabinetReserveExists
+	}
+
+	writer := bufio.NewWriter(output)
+	binary.Write(writer, binary.LittleEndian, header)
+	if reserve != (Reserve{}) {
+		binary.Write(writer, binary.LittleEndian, cabinetFileReservedSizes{
+			ReservedHeaderSize:    uint16(reserve.Header),
+			ReservedFolderSize:    sizes.ReservedFolderSize,
+			ReservedDatablockSize: sizes.ReservedDatablockSize,
+		})
+		writer.Write(data)
+	}
+	// The names of the previous and next cabinet
+	if _, err := io.Copy(writer, io.NewSectionReader(cabinet, namesOffset, foldersOffset-namesOffset)); err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		if folder.CfDataCount > 0 {
+			folder.CoffCabStart = uint32(int64(folder.CoffCabStart) + shift)
+		}
+		binary.Write(writer, binary.LittleEndian, folder.cabinetFileFolderHeader)
+		writer.Write(folder.reservedData)
+	}
+	// The CFFILE entries and the data blocks
+	if _, err := io.Copy(writer, io.NewSectionReader(cabinet, source.position, end-source.position)); err != nil {
+		return err
+	}
+	// bufio.Writer keeps the first error, so it is enough to check it when flushing
+	return writer.Flush()
+}
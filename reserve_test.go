@@ -0,0 +1,421 @@
+package cab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeReservedCabinet writes the test files to a cabinet with the given reserve.
+func writeReservedCabinet(t *testing.T, files []writerTestFile, reserve Reserve, compression Compression) string {
+	t.Helper()
+	return writeTestCabinet(t, files, func(w *Writer, index int) error {
+		if index > 0 {
+			return nil
+		}
+		if err := w.SetReserve(reserve); err != nil {
+			return err
+		}
+		return w.NewFolder(compression)
+	})
+}
+
+func TestWriterReserve(t *testing.T) {
+	files := writerTestFiles()
+	for _, reserve := range []Reserve{
+		{Header: AuthenticodeHeaderReserve},
+		{Header: 100, Folder: 7, Data: 5},
+		{Folder: 3},
+		{Data: 255},
+		{Header: maxHeaderReserve, Folder: 255, Data: 1},
+	} {
+		for _, compression := range []Compression{CompressionNone, CompressionMSZIP} {
+			path := writeReservedCabinet(t, files, reserve, compression)
+			// Reading verifies the checksums, which cover the reserved area of each data block
+			cabFile := checkTestCabinet(t, path, files)
+			if cabFile.Reserve != reserve {
+				t.Fatal("unexpected reserve", cabFile.Reserve)
+			}
+			if !bytes.Equal(cabFile.ReservedHeaderBlock, make([]byte, reserve.Header)) {
+				t.Fatal("unexpected reserved header area", cabFile.ReservedHeaderBlock)
+			}
+			for _, file := range cabFile.Files {
+				if !bytes.Equal(file.ReservedFolderArea(), make([]byte, reserve.Folder)) {
+					t.Fatal("unexpected reserved folder area", file.ReservedFolderArea())
+				}
+				areas := file.ReservedDataAreas()
+				if len(areas) != len(file.folder.dataEntries) {
+					t.Fatal("unexpected number of reserved data areas", len(areas))
+				}
+				for _, area := range areas {
+					if !bytes.Equal(area, make([]byte, reserve.Data)) {
+						t.Fatal("unexpected reserved data area", area)
+					}
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream := NewStreamReader(bytes.NewReader(data))
+			for {
+				if _, err := stream.Next(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if stream.Reserve != reserve {
+				t.Fatal("unexpected reserve in stream", stream.Reserve)
+			}
+		}
+	}
+}
+
+func TestSetWriterReserve(t *testing.T) {
+	files := writerTestFiles()
+	reserve := Reserve{Header: AuthenticodeHeaderReserve, Folder: 4, Data: 8}
+	var readers []io.ReaderAt
+	var sizes []int64
+	writer, err := NewSetWriter(SetOptions{
+		MaxSize:      20000,
+		NameTemplate: "disk*.cab",
+		Create: func(name string) (io.WriteCloser, error) {
+			return &bufferCloser{onClose: func(data []byte) {
+				readers = append(readers, bytes.NewReader(data))
+				sizes = append(sizes, int64(len(data)))
+			}}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.SetReserve(reserve); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		fileWriter, err := writer.Create(file.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileWriter.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) < 2 {
+		t.Fatal("expected several cabinets, got", len(sizes))
+	}
+	for _, size := range sizes {
+		if size > 20000 {
+			t.Fatal("cabinet is too large", size)
+		}
+	}
+	cabFile, err := OpenSetWithOptions(readers, sizes, &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cabFile.Reserve != reserve {
+		t.Fatal("unexpected reserve", cabFile.Reserve)
+	}
+	checkTestFiles(t, cabFile, files)
+	for _, file := range cabFile.Files {
+		// Blocks that are split across cabinets have an area in each cabinet
+		areas := file.ReservedDataAreas()
+		if len(file.ReservedFolderArea()) != reserve.Folder || len(areas) < len(file.folder.dataEntries) {
+			t.Fatal("unexpected reserved areas of", file.Name)
+		}
+		for _, area := range areas {
+			if len(area) != reserve.Data {
+				t.Fatal("unexpected reserved data area", area)
+			}
+		}
+	}
+}
+
+type bufferCloser struct {
+	bytes.Buffer
+	onClose func(data []byte)
+}
+
+func (b *bufferCloser) Close() error {
+	b.onClose(b.Bytes())
+	return nil
+}
+
+func TestPatchReservedHeader(t *testing.T) {
+	files := writerTestFiles()
+	path := writeReservedCabinet(t, files, Reserve{Header: AuthenticodeHeaderReserve, Data: 4}, CompressionMSZIP)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	signature := bytes.Repeat([]byte{0xA5}, AuthenticodeHeaderReserve)
+	if err := PatchReservedHeader(file, signature); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{nil, make([]byte, AuthenticodeHeaderReserve+1)} {
+		if err := PatchReservedHeader(file, data); err == nil {
+			t.Fatal("expected error for reserved header of size", len(data))
+		}
+	}
+
+	cabFile := checkTestCabinet(t, path, files)
+	if !bytes.Equal(cabFile.ReservedHeaderBlock, signature) {
+		t.Fatal("unexpected reserved header area", cabFile.ReservedHeaderBlock)
+	}
+	patched, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the reserved header area changed
+	offset := 40
+	if len(patched) != len(original) || !bytes.Equal(patched[:offset], original[:offset]) ||
+		!bytes.Equal(patched[offset+AuthenticodeHeaderReserve:], original[offset+AuthenticodeHeaderReserve:]) {
+		t.Fatal("patching changed more than the reserved header area")
+	}
+}
+
+// TestSignedCabinet checks a cabinet in the layout of signtool: signed.cab is dummy.cab from the functional tests
+// of relic (Apache License 2.0), signed by relic's cabfile package with a placeholder signature. The reserved header
+// area contains the size of the cabinet without the signature and the size of the signature, which is appended.
+func TestSignedCabinet(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "signed.cab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flags and cbCFHeader
+	if flags := binary.LittleEndian.Uint16(original[30:]); flags != cabinetReserveExists {
+		t.Fatal("unexpected flags", flags)
+	}
+	cabFile, err := Open(bytes.NewReader(original), int64(len(original)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cabFile.Reserve != (Reserve{Header: AuthenticodeHeaderReserve}) {
+		t.Fatal("unexpected reserve", cabFile.Reserve)
+	}
+	cabinetSize := binary.LittleEndian.Uint32(cabFile.ReservedHeaderBlock[4:])
+	signatureSize := binary.LittleEndian.Uint32(cabFile.ReservedHeaderBlock[8:])
+	if cabinetSize != binary.LittleEndian.Uint32(original[8:]) || int(cabinetSize+signatureSize) != len(original) {
+		t.Fatal("unexpected signature location", cabinetSize, signatureSize)
+	}
+	checkSignedFile := func(cabFile *Cabinet) {
+		t.Helper()
+		if len(cabFile.Files) != 1 || cabFile.Files[0].Name != "dummy.wxs" {
+			t.Fatal("unexpected files", cabFile.Files)
+		}
+		reader, err := cabFile.Files[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash := fmt.Sprintf("%X", sha256.Sum256(data)); hash != "A1A2DBDD3A82CCF203E844E496DC3BE64E906CC3D312EB3A8436E3108935492B" {
+			t.Fatal("unexpected hash", hash)
+		}
+		if len(cabFile.Files[0].ReservedFolderArea()) != 0 || len(cabFile.Files[0].ReservedDataAreas()[0]) != 0 {
+			t.Fatal("unexpected reserved folder or data area")
+		}
+	}
+	checkSignedFile(cabFile)
+
+	path := filepath.Join(t.TempDir(), "signed.cab")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	header := append([]byte(nil), cabFile.ReservedHeaderBlock...)
+	binary.LittleEndian.PutUint32(header[8:], signatureSize-8)
+	if err := PatchReservedHeader(file, header); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := 40
+	if !bytes.Equal(patched[:offset], original[:offset]) || !bytes.Equal(patched[offset+AuthenticodeHeaderReserve:], original[offset+AuthenticodeHeaderReserve:]) {
+		t.Fatal("patching changed more than the reserved header area")
+	}
+	cabFile, err = Open(bytes.NewReader(patched), int64(len(patched)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cabFile.ReservedHeaderBlock, header) {
+		t.Fatal("unexpected reserved header area", cabFile.ReservedHeaderBlock)
+	}
+	checkSignedFile(cabFile)
+}
+
+func TestAddReservedHeader(t *testing.T) {
+	simple, err := os.ReadFile(filepath.Join("testdata", "simple.cab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(writeReservedCabinet(t, writerTestFiles(), Reserve{Header: 100, Folder: 7, Data: 5}, CompressionMSZIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := os.ReadFile(filepath.Join("testdata", "signed.cab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		original []byte
+		header   []byte
+		reserve  Reserve
+	}{
+		{"unsigned", simple, bytes.Repeat([]byte{0xA5}, AuthenticodeHeaderReserve), Reserve{Header: AuthenticodeHeaderReserve}},
+		{"smaller", written, make([]byte, AuthenticodeHeaderReserve), Reserve{Header: AuthenticodeHeaderReserve, Folder: 7, Data: 5}},
+		{"removed", signed, nil, Reserve{}},
+	} {
+		var output bytes.Buffer
+		if err := AddReservedHeader(&output, bytes.NewReader(test.original), test.header); err != nil {
+			t.Fatal(test.name, err)
+		}
+		rewritten := output.Bytes()
+		cabFile, err := OpenWithOptions(bytes.NewReader(rewritten), int64(len(rewritten)), &Options{Strict: true})
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if cabFile.Reserve != test.reserve || !bytes.Equal(cabFile.ReservedHeaderBlock, test.header) {
+			t.Fatal(test.name, "unexpected reserve", cabFile.Reserve, cabFile.ReservedHeaderBlock)
+		}
+		if int(binary.LittleEndian.Uint32(rewritten[8:])) != len(rewritten) {
+			t.Fatal(test.name, "unexpected cabinet size", len(rewritten))
+		}
+		// The streamed contents verify the checksums, which cover the reserved data areas
+		expected := readStream(t, test.original[:binary.LittleEndian.Uint32(test.original[8:])], nil)
+		contents := readStream(t, rewritten, nil)
+		if len(contents) != len(expected) {
+			t.Fatal(test.name, "unexpected number of files", len(contents))
+		}
+		for name, data := range expected {
+			if !bytes.Equal(contents[name], data) {
+				t.Fatal(test.name, "content differs for", name)
+			}
+		}
+	}
+
+	// Each cabinet of a set is rewritten on its own
+	var readers []io.ReaderAt
+	var sizes []int64
+	for i, volume := range testSet() {
+		var output bytes.Buffer
+		if err := AddReservedHeader(&output, bytes.NewReader(volume), make([]byte, 10*i)); err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, bytes.NewReader(output.Bytes()))
+		sizes = append(sizes, int64(output.Len()))
+	}
+	cabFile, err := OpenSetWithOptions(readers, sizes, &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := testSetContent()
+	for _, file := range cabFile.Files {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		start := file.header.UncompressedOffsetInFolder
+		if !bytes.Equal(data, content[start:start+file.header.UncompressedFileSize]) {
+			t.Fatal("content differs for", file.Name)
+		}
+	}
+
+	// The reserved header area of the rewritten unsigned cabinet can be patched
+	var output bytes.Buffer
+	if err := AddReservedHeader(&output, bytes.NewReader(simple), make([]byte, AuthenticodeHeaderReserve)); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "reserved.cab")
+	if err := os.WriteFile(path, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := PatchReservedHeader(file, bytes.Repeat([]byte{0xA5}, AuthenticodeHeaderReserve)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{simple[:len(simple)-1], []byte("not a cabinet")} {
+		if err := AddReservedHeader(io.Discard, bytes.NewReader(data), nil); err == nil {
+			t.Fatal("expected error for", len(data), "bytes")
+		}
+	}
+	if err := AddReservedHeader(io.Discard, bytes.NewReader(simple), make([]byte, maxHeaderReserve+1)); err == nil {
+		t.Fatal("expected error for a too large reserved header area")
+	}
+}
+
+func TestPatchReservedHeaderErrors(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "simple.cab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "simple.cab")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := PatchReservedHeader(file, nil); !errors.Is(err, ErrReservedHeaderSize) {
+		t.Fatal("expected error for cabinet without reserved areas", err)
+	}
+
+	notCabinet := filepath.Join(t.TempDir(), "text.txt")
+	if err := os.WriteFile(notCabinet, bytes.Repeat([]byte("text"), 20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	text, err := os.OpenFile(notCabinet, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer text.Close()
+	if err := PatchReservedHeader(text, nil); err == nil {
+		t.Fatal("expected error for file that is not a cabinet")
+	}
+}
+
+func TestSetReserveErrors(t *testing.T) {
+	writer := NewWriter(nil)
+	for _, reserve := range []Reserve{{Header: -1}, {Header: maxHeaderReserve + 1}, {Folder: 256}, {Data: -1}} {
+		if err := writer.SetReserve(reserve); err == nil {
+			t.Error("expected error for reserve", reserve)
+		}
+	}
+}
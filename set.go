@@ -0,0 +1,136 @@
+package cab
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OpenSet opens a multi-cabinet set with the default options. The readers must be passed in the order of the set,
+// starting with the first cabinet; sizes contains the size of each reader.
+//
+// Folders that are continued across cabinets are merged, so that every file in the returned Cabinet can be opened
+// regardless of the cabinet its data starts in. Files that are listed in several cabinets because they span
+// cabinet boundaries are only returned once.
+func OpenSet(readers []io.ReaderAt, sizes []int64) (*Cabinet, error) {
+	return OpenSetWithOptions(readers, sizes, nil)
+}
+
+// OpenSetWithOptions opens a multi-cabinet set like OpenSet, using the given options for every cabinet.
+func OpenSetWithOptions(readers []io.ReaderAt, sizes []int64, opts *Options) (*Cabinet, error) {
+	if len(readers) == 0 {
+		return nil, errors.New("no cabinets in set")
+	}
+	if len(readers) != len(sizes) {
+		return nil, errors.New("number of readers and sizes differ")
+	}
+	// Normalize once, so that all cabinets share the checksum report
+	options := opts.normalized()
+	var cabinets []*Cabinet
+	for i := range readers {
+		cab, err := OpenWithOptions(readers[i], sizes[i], &options)
+		if err != nil {
+			return nil, err
+		}
+		cabinets = append(cabinets, cab)
+	}
+	return mergeSet(cabinets, nil)
+}
+
+// mergeSet merges the cabinets of a multi-cabinet set into a single Cabinet. names contains the file names of the
+// cabinets, if known.
+func mergeSet(cabinets []*Cabinet, names []string) (*Cabinet, error) {
+	if len(cabinets) > 1 {
+		// Errors and checksum mismatches of data blocks name the cabinet; the blocks are copied when folders are merged
+		for i, cab := range cabinets {
+			volume := &setVolume{index: int(cab.SetIndex)}
+			if names != nil {
+				volume.name = names[i]
+			}
+			for _, folder := range cab.folders {
+				for j := range folder.dataEntries {
+					folder.dataEntries[j].volume = volume
+				}
+			}
+		}
+	}
+	first := cabinets[0]
+	merged := &Cabinet{
+		Files:               append([]*File(nil), first.Files...),
+		ReservedHeaderBlock: first.ReservedHeaderBlock,
+		Reserve:             first.Reserve,
+		MultiCabinetInfo:    first.MultiCabinetInfo,
+		folders:             append([]*cabinetFileFolder(nil), first.folders...),
+		checksums:           first.checksums,
+	}
+	for i, cab := range cabinets[1:] {
+		previous := cabinets[i]
+		if cab.SetId != previous.SetId {
+			return nil, fmt.Errorf("%w: cabinet belongs to a different set", ErrInvalidSet)
+		}
+		if cab.SetIndex != previous.SetIndex+1 {
+			return nil, fmt.Errorf("%w: cabinets in set are not consecutive", ErrInvalidSet)
+		}
+
+		var lastFolder *cabinetFileFolder
+		if len(merged.folders) > 0 {
+			lastFolder = merged.folders[len(merged.folders)-1]
+		}
+		folders := cab.folders
+		var continuedFolder *cabinetFileFolder
+		if len(folders) > 0 && folders[0].continuedFromPrevious {
+			if lastFolder == nil || !lastFolder.continuesToNext {
+				return nil, fmt.Errorf("%w: folder is continued, but the previous cabinet has no continuing folder", ErrInvalidSet)
+			}
+			if err := lastFolder.appendContinuation(folders[0]); err != nil {
+				return nil, err
+			}
+			continuedFolder = folders[0]
+			folders = folders[1:]
+		} else if lastFolder != nil && lastFolder.continuesToNext {
+			return nil, fmt.Errorf("%w: folder continues into the next cabinet, but the next cabinet does not continue it", ErrInvalidSet)
+		}
+		merged.folders = append(merged.folders, folders...)
+
+		for _, file := range cab.Files {
+			switch file.header.FolderIndex {
+			case folderIndexContinuedFromPrevious, folderIndexContinuedPreviousAndNext:
+				// File was already listed in the previous cabinet
+				continue
+			}
+			if file.folder == continuedFolder {
+				file.folder = lastFolder
+			}
+			merged.Files = append(merged.Files, file)
+		}
+	}
+	last := cabinets[len(cabinets)-1]
+	merged.NextFile = last.NextFile
+	merged.NextDisk = last.NextDisk
+	return merged, nil
+}
+
+// appendContinuation appends the data blocks of the continuation of this folder in the next cabinet.
+// If the last data block of this folder was split, it is joined with the first block of the continuation.
+func (folder *cabinetFileFolder) appendContinuation(continuation *cabinetFileFolder) error {
+	if folder.CompressionType != continuation.CompressionType {
+		return fmt.Errorf("%w: compression type of continued folder differs", ErrInvalidSet)
+	}
+	dataEntries := continuation.dataEntries
+	if len(folder.dataEntries) > 0 {
+		tail := &folder.dataEntries[len(folder.dataEntries)-1]
+		for tail.next != nil {
+			tail = tail.next
+		}
+		if tail.UncompressedBytes == 0 {
+			if len(dataEntries) == 0 {
+				return fmt.Errorf("%w: split data block is not continued", ErrInvalidSet)
+			}
+			tail.next = &dataEntries[0]
+			dataEntries = dataEntries[1:]
+		}
+	}
+	folder.dataEntries = append(folder.dataEntries, dataEntries...)
+	folder.continuesToNext = continuation.continuesToNext
+	return nil
+}
@@ -0,0 +1,304 @@
+package cab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type testVolume struct {
+	setIndex       uint16
+	previous, next string
+	blocks         []testBlock // Blocks of a single, uncompressed folder
+	files          []testFile
+	filesAfterData bool // Place the CFFILE entries after the CFDATA blocks
+}
+
+type testBlock struct {
+	data         []byte
+	uncompressed uint16
+}
+
+type testFile struct {
+	name        string
+	offset      uint32
+	size        uint32
+	folderIndex uint16
+}
+
+// buildTestVolume creates a cabinet with a single uncompressed folder, which may be part of a multi-cabinet set.
+func buildTestVolume(volume testVolume) []byte {
+	var names bytes.Buffer
+	var flags uint16
+	if volume.previous != "" {
+		flags |= previousCabinetExists
+		names.WriteString(volume.previous + "\x00disk\x00")
+	}
+	if volume.next != "" {
+		flags |= nextCabinetExists
+		names.WriteString(volume.next + "\x00disk\x00")
+	}
+	var files bytes.Buffer
+	for _, file := range volume.files {
+		binary.Write(&files, binary.LittleEndian, cabinetFileEntryHeader{
+			UncompressedFileSize:       file.size,
+			UncompressedOffsetInFolder: file.offset,
+			FolderIndex:                file.folderIndex,
+			Date:                       0x5362,
+			Time:                       0x745c,
+			Attributes:                 AttributeArch,
+		})
+		files.WriteString(file.name + "\x00")
+	}
+	fileOffset := 36 + names.Len() + 8
+	dataOffset := fileOffset + files.Len()
+
+	var data bytes.Buffer
+	for _, block := range volume.blocks {
+		var checksum checksumWriter
+		checksum.Write(block.data)
+		checksum.Flush()
+		binary.Write(&checksum, binary.LittleEndian, checksumlessEntry{uint16(len(block.data)), block.uncompressed})
+		binary.Write(&data, binary.LittleEndian, cabinetFileDataHeader{
+			Checksum:          checksum.Checksum,
+			CompressedBytes:   uint16(len(block.data)),
+			UncompressedBytes: block.uncompressed,
+		})
+		data.Write(block.data)
+	}
+
+	if volume.filesAfterData {
+		dataOffset = fileOffset
+		fileOffset = dataOffset + data.Len()
+	}
+
+	var cab bytes.Buffer
+	binary.Write(&cab, binary.LittleEndian, cabinetFileHeader{
+		Signature:            [4]byte{'M', 'S', 'C', 'F'},
+		Filesize:             uint32(36 + names.Len() + 8 + files.Len() + data.Len()),
+		FirstFileEntryOffset: uint32(fileOffset),
+		VersionMinor:         3,
+		VersionMajor:         1,
+		FolderCount:          1,
+		FileCount:            uint16(len(volume.files)),
+		Flags:                flags,
+		SetId:                0x1234,
+		SetIndex:             volume.setIndex,
+	})
+	cab.Write(names.Bytes())
+	binary.Write(&cab, binary.LittleEndian, cabinetFileFolderHeader{
+		CoffCabStart: uint32(dataOffset),
+		CfDataCount:  uint16(len(volume.blocks)),
+	})
+	if volume.filesAfterData {
+		cab.Write(data.Bytes())
+		cab.Write(files.Bytes())
+	} else {
+		cab.Write(files.Bytes())
+		cab.Write(data.Bytes())
+	}
+	return cab.Bytes()
+}
+
+func testSetContent() []byte {
+	content := make([]byte, 3500)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	return content
+}
+
+// testSet returns a set of two cabinets where the second file spans both cabinets and its data block is split.
+func testSet() [][]byte {
+	content := testSetContent()
+	first := buildTestVolume(testVolume{
+		setIndex: 0,
+		next:     "second.cab",
+		blocks: []testBlock{
+			{content[:1500], 1500},
+			{content[1500:2200], 0},
+		},
+		files: []testFile{
+			{"first.bin", 0, 1000, 0},
+			{"second.bin", 1000, 2000, folderIndexContinuedToNext},
+		},
+	})
+	second := buildTestVolume(testVolume{
+		setIndex: 1,
+		previous: "first.cab",
+		blocks: []testBlock{
+			{content[2200:3000], 1500},
+			{content[3000:], 500},
+		},
+		files: []testFile{
+			{"second.bin", 1000, 2000, folderIndexContinuedFromPrevious},
+			{"third.bin", 3000, 500, 0},
+		},
+	})
+	return [][]byte{first, second}
+}
+
+func TestOpenSet(t *testing.T) {
+	volumes := testSet()
+	var readers []io.ReaderAt
+	var sizes []int64
+	for _, volume := range volumes {
+		readers = append(readers, bytes.NewReader(volume))
+		sizes = append(sizes, int64(len(volume)))
+	}
+	cabFile, err := OpenSet(readers, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cabFile.PreviousFile != "" || cabFile.NextFile != "" {
+		t.Fatal("unexpected set neighbours", cabFile.MultiCabinetInfo)
+	}
+
+	content := testSetContent()
+	expected := map[string][]byte{
+		"first.bin":  content[:1000],
+		"second.bin": content[1000:3000],
+		"third.bin":  content[3000:],
+	}
+	if len(cabFile.Files) != len(expected) {
+		t.Fatal("expected", len(expected), "files, got", len(cabFile.Files))
+	}
+	for _, file := range cabFile.Files {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected[file.Name]) {
+			t.Fatal("content mismatch for", file.Name)
+		}
+	}
+}
+
+func TestOpenSetNotConsecutive(t *testing.T) {
+	volumes := testSet()
+	readers := []io.ReaderAt{bytes.NewReader(volumes[1]), bytes.NewReader(volumes[0])}
+	sizes := []int64{int64(len(volumes[1])), int64(len(volumes[0]))}
+	if _, err := OpenSet(readers, sizes); err == nil {
+		t.Fatal("expected error for cabinets in wrong order")
+	}
+}
+
+func TestOpenSpanningFileInSingleCabinet(t *testing.T) {
+	for _, volume := range testSet() {
+		cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range cabFile.Files {
+			if file.Name != "second.bin" {
+				continue
+			}
+			reader, err := file.Open()
+			if err == nil {
+				_, err = io.ReadAll(reader)
+			}
+			if err == nil {
+				t.Fatal("expected error when reading spanning file from a single cabinet")
+			}
+		}
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	volumes := testSet()
+	fsys := fstest.MapFS{
+		"set/FIRST.CAB":  {Data: volumes[0]},
+		"set/second.cab": {Data: volumes[1]},
+	}
+	for _, name := range []string{"set/FIRST.CAB", "set/second.cab"} {
+		cabFile, err := OpenFS(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cabFile.Files) != 3 {
+			t.Fatal("expected 3 files, got", len(cabFile.Files))
+		}
+		if err := cabFile.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenFSChecksumMismatch(t *testing.T) {
+	volumes := testSet()
+	// Corrupt the checksum of the last data block, which is the second block of the folder in the second cabinet
+	blockOffset := len(volumes[1]) - 8 - 500
+	volumes[1][blockOffset] ^= 0xFF
+	fsys := fstest.MapFS{
+		"set/first.cab":  {Data: volumes[0]},
+		"set/second.cab": {Data: volumes[1]},
+	}
+	readThird := func(opts *Options) (*Cabinet, error) {
+		cabFile, err := OpenFSWithOptions(fsys, "set/first.cab", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := cabFile.Files[2].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(reader)
+		return cabFile, err
+	}
+
+	_, err := readThird(nil)
+	formatErr := expectFormatError(t, err, ErrChecksumMismatch, StructureData)
+	if formatErr.Folder != 0 || formatErr.Block != 1 || formatErr.Offset != int64(blockOffset) ||
+		formatErr.Volume != 1 || formatErr.VolumeName != "set/second.cab" {
+		t.Fatal("unexpected position", formatErr)
+	}
+	if !strings.Contains(formatErr.Error(), "in cabinet 1 (set/second.cab)") {
+		t.Fatal("cabinet missing from error", formatErr)
+	}
+
+	cabFile, err := readThird(&Options{Checksums: ChecksumReport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches := cabFile.ChecksumMismatches()
+	if len(mismatches) != 1 || mismatches[0].Folder != 0 || mismatches[0].Block != 1 || mismatches[0].Volume != 1 ||
+		mismatches[0].VolumeName != "set/second.cab" {
+		t.Fatal("unexpected mismatches", mismatches)
+	}
+}
+
+func TestOpenFSMissingVolume(t *testing.T) {
+	volumes := testSet()
+	fsys := fstest.MapFS{
+		"second.cab": {Data: volumes[1]},
+	}
+	if _, err := OpenFS(fsys, "second.cab"); err == nil {
+		t.Fatal("expected error for missing volume")
+	}
+}
+
+func TestOpenFileBeforeSplitBlock(t *testing.T) {
+	volume := testSet()[0]
+	cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := cabFile.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testSetContent()[:1000]) {
+		t.Fatal("content mismatch")
+	}
+}
@@ -0,0 +1,324 @@
+package cab
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SetOptions configures a Writer that creates a multi-cabinet set, see NewSetWriter.
+type SetOptions struct {
+	// MaxSize is the maximum size of each cabinet in bytes.
+	MaxSize int64
+	// NameTemplate is the file name of the cabinets. "*" is replaced by the number of the cabinet, starting at 1, like
+	// in MakeCAB's CabinetNameTemplate. The names are stored in the cabinets, so that OpenFS can find the other
+	// cabinets of the set. Like in MakeCAB, a template without "*" is only valid as long as a single cabinet is
+	// needed.
+	NameTemplate string
+	// DiskTemplate is the name of the disk that each cabinet is stored on, with "*" replaced like in NameTemplate.
+	// It may be empty.
+	DiskTemplate string
+	// Create is called for each cabinet, in order, and must return a writer for it. The writer is closed after the
+	// cabinet was written.
+	Create func(name string) (io.WriteCloser, error)
+}
+
+// NewSetWriter returns a Writer that writes a multi-cabinet set. Files are added like with NewWriter, and the data is
+// split into cabinets that are at most options.MaxSize bytes large. Folders and data blocks are continued across
+// cabinets where necessary. All cabinets share the set ID, see Writer.SetID.
+//
+// Each cabinet is written as soon as the following cabinet was started and all files that it lists are complete,
+// and its data is released afterwards; Close writes the remaining cabinets.
+func NewSetWriter(options SetOptions) (*Writer, error) {
+	if options.MaxSize <= 0 {
+		return nil, errors.New("invalid maximum cabinet size")
+	}
+	if options.NameTemplate == "" {
+		return nil, errors.New("no cabinet name template")
+	}
+	if options.Create == nil {
+		return nil, errors.New("no function to create cabinets")
+	}
+	return &Writer{set: &options, compression: CompressionMSZIP, newFolder: true}, nil
+}
+
+func (o *SetOptions) cabinetName(index int) string {
+	return strings.ReplaceAll(o.NameTemplate, "*", strconv.Itoa(index+1))
+}
+
+func (o *SetOptions) diskName(index int) string {
+	return strings.ReplaceAll(o.DiskTemplate, "*", strconv.Itoa(index+1))
+}
+
+// layOut passes the data blocks of a folder to the layout of the set and writes the cabinets that are complete.
+// The last block is kept back until the folder is finished, since files that start at its end may still be created.
+func (w *Writer) layOut(folder *writerFolder, finished bool) error {
+	if w.layout == nil {
+		w.layout = &setLayout{options: w.set, setID: w.setID, reserve: w.reserve, spans: map[*writerFile]*fileSpan{}}
+		if err := w.layout.startVolume(); err != nil {
+			return err
+		}
+	}
+	l := w.layout
+	if folder != nil {
+		if finished && len(folder.blocks) == 0 {
+			if err := l.addEmptyFolder(folder); err != nil {
+				return err
+			}
+		}
+		end := len(folder.blocks)
+		if !finished {
+			end--
+		}
+		for ; folder.laidOut < end; folder.laidOut++ {
+			if l.folder != folder {
+				l.startFolder(folder)
+			}
+			if err := l.addBlock(folder.blocks[folder.laidOut]); err != nil {
+				return err
+			}
+			// The cabinets refer to the data now
+			folder.blocks[folder.laidOut].data = nil
+		}
+	}
+	return l.flush(w.current, w.closed)
+}
+
+// setLayout distributes folders over the cabinets of a set while they are written. A file is listed in every cabinet
+// that contains data blocks overlapping the file, including blocks that end or start exactly at the file's
+// boundaries. Thereby every folder that is continued in the next cabinet has a file that marks the continuation.
+type setLayout struct {
+	options *SetOptions
+	setID   uint16
+	reserve Reserve
+	volumes []*writerVolume // Cabinets that were not written yet, the last one is the current cabinet
+	written int             // Number of cabinets that were written
+	used    int64           // Size of the current cabinet, including the names of the next cabinet
+	spans   map[*writerFile]*fileSpan
+
+	// State of the folder whose blocks are added
+	folder      *writerFolder
+	part        *volumeFolder // Part of the folder in the current cabinet
+	first, next int           // Files before first end before the current part, files from next on are not listed yet
+	blockStart  int64
+}
+
+// fileSpan contains the indices of the first and last cabinet that a file is listed in.
+type fileSpan struct {
+	first, last int
+}
+
+// current returns the cabinet that blocks are added to.
+func (l *setLayout) current() *writerVolume {
+	return l.volumes[len(l.volumes)-1]
+}
+
+func (l *setLayout) startVolume() error {
+	index := l.written + len(l.volumes)
+	if index == 0xFFFF {
+		return errors.New("too many cabinets in set")
+	}
+	if index > 0 && !strings.Contains(l.options.NameTemplate, "*") {
+		return fmt.Errorf("cabinet name template %q does not contain *, but more than one cabinet is needed", l.options.NameTemplate)
+	}
+	for _, name := range []string{l.options.cabinetName(index), l.options.diskName(index)} {
+		if len(name) > maxNameLength {
+			return fmt.Errorf("cabinet or disk name %q is too long", name)
+		}
+	}
+	l.volumes = append(l.volumes, &writerVolume{reserve: l.reserve})
+	l.used = int64(binary.Size(cabinetFileHeader{})) + l.reserve.headerSize()
+	if index > 0 {
+		l.used += int64(len(l.options.cabinetName(index-1)) + len(l.options.diskName(index-1)) + 2)
+	}
+	// The names of the next cabinet are not needed in the last cabinet, but that is not known yet
+	l.used += int64(len(l.options.cabinetName(index+1)) + len(l.options.diskName(index+1)) + 2)
+	return nil
+}
+
+// nextVolume starts the next cabinet, failing if the current cabinet is empty, since then nothing fits into a
+// cabinet.
+func (l *setLayout) nextVolume() error {
+	if len(l.current().folders) == 0 {
+		return fmt.Errorf("maximum cabinet size %d is too small", l.options.MaxSize)
+	}
+	l.part = nil
+	return l.startVolume()
+}
+
+func (l *setLayout) fits(size int64) bool {
+	return l.used+size <= l.options.MaxSize
+}
+
+func fileEntrySize(files []*writerFile) int64 {
+	var size int64
+	for _, file := range files {
+		size += int64(binary.Size(cabinetFileEntryHeader{}) + len(file.name) + 1)
+	}
+	return size
+}
+
+func (l *setLayout) folderEntrySize() int64 {
+	return int64(binary.Size(cabinetFileFolderHeader{}) + l.reserve.Folder)
+}
+
+// newPart adds a part of a folder to the current cabinet.
+func (l *setLayout) newPart(folder *writerFolder) *volumeFolder {
+	part := &volumeFolder{compression: folder.compression}
+	current := l.current()
+	current.folders = append(current.folders, part)
+	return part
+}
+
+// list adds files to the current cabinet, in the last folder of the cabinet.
+func (l *setLayout) list(files []*writerFile) {
+	index := l.written + len(l.volumes) - 1
+	current := l.current()
+	for _, file := range files {
+		current.files = append(current.files, volumeFile{writerFile: file, folderIndex: uint16(len(current.folders) - 1)})
+		if span := l.spans[file]; span != nil {
+			span.last = index
+		} else {
+			l.spans[file] = &fileSpan{first: index, last: index}
+			file.cabinet = index
+		}
+	}
+}
+
+// addEmptyFolder adds a folder without data blocks, which only contains empty files.
+func (l *setLayout) addEmptyFolder(folder *writerFolder) error {
+	size := l.folderEntrySize() + fileEntrySize(folder.files)
+	if !l.fits(size) {
+		if err := l.nextVolume(); err != nil {
+			return err
+		}
+		if !l.fits(size) {
+			return fmt.Errorf("maximum cabinet size %d is too small", l.options.MaxSize)
+		}
+	}
+	l.newPart(folder)
+	l.list(folder.files)
+	l.used += size
+	return nil
+}
+
+// startFolder starts adding the blocks of a folder.
+func (l *setLayout) startFolder(folder *writerFolder) {
+	l.folder = folder
+	l.part = nil
+	l.first, l.next = 0, 0
+	l.blockStart = 0
+}
+
+// addBlock adds the next data block of the current folder, splitting it if it does not fit into the current cabinet.
+// All files of the folder that start at or before the end of the block must have been created.
+func (l *setLayout) addBlock(block writerBlock) error {
+	files := l.folder.files
+	dataHeaderSize := int64(binary.Size(cabinetFileDataHeader{}) + l.reserve.Data)
+	blockEnd := l.blockStart + int64(block.uncompressed)
+	data := block.data
+	for {
+		var size int64
+		if l.part == nil {
+			size += l.folderEntrySize()
+			for l.first < len(files) && int64(files[l.first].UncompressedOffsetInFolder+files[l.first].UncompressedFileSize) < l.blockStart {
+				l.first++
+			}
+			l.next = l.first
+		}
+		end := l.next
+		for end < len(files) && int64(files[end].UncompressedOffsetInFolder) <= blockEnd {
+			end++
+		}
+		size += fileEntrySize(files[l.next:end]) + dataHeaderSize
+		if l.fits(size + int64(len(data))) {
+			if l.part == nil {
+				l.part = l.newPart(l.folder)
+			}
+			l.part.blocks = append(l.part.blocks, writerBlock{data: data, uncompressed: block.uncompressed})
+			l.list(files[l.next:end])
+			l.used += size + int64(len(data))
+			l.next = end
+			break
+		}
+		// Split the block, unless not even a byte of data fits
+		if available := l.options.MaxSize - l.used - size; available > 0 {
+			if l.part == nil {
+				l.part = l.newPart(l.folder)
+			}
+			l.part.blocks = append(l.part.blocks, writerBlock{data: data[:available]})
+			l.list(files[l.next:end])
+			l.next = end
+			data = data[available:]
+		}
+		if err := l.nextVolume(); err != nil {
+			return err
+		}
+	}
+	l.blockStart = blockEnd
+	return nil
+}
+
+// flush writes the cabinets before the current one whose files are complete, that is, none of them is current, the
+// file that is still being written. Once the following cabinet was started, the cabinets that list a file are known.
+// If all is set, the current cabinet is written as well, as the last cabinet of the set.
+func (l *setLayout) flush(current *writerFile, all bool) error {
+	for len(l.volumes) > 1 || (all && len(l.volumes) > 0) {
+		volume := l.volumes[0]
+		for _, file := range volume.files {
+			if file.writerFile == current && !all {
+				return nil
+			}
+		}
+		l.finish(volume, l.written, len(l.volumes) == 1)
+		output, err := l.options.Create(l.options.cabinetName(l.written))
+		if err != nil {
+			return err
+		}
+		err = volume.write(output)
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		for _, file := range volume.files {
+			if l.spans[file.writerFile].last == l.written {
+				delete(l.spans, file.writerFile)
+			}
+		}
+		l.volumes[0] = nil
+		l.volumes = l.volumes[1:]
+		l.written++
+	}
+	return nil
+}
+
+// finish sets the set information of a cabinet and the folder indices of files that span cabinets.
+func (l *setLayout) finish(volume *writerVolume, index int, last bool) {
+	volume.SetId = l.setID
+	volume.SetIndex = uint16(index)
+	if index > 0 {
+		volume.PreviousFile = l.options.cabinetName(index - 1)
+		volume.PreviousDisk = l.options.diskName(index - 1)
+	}
+	if !last {
+		volume.NextFile = l.options.cabinetName(index + 1)
+		volume.NextDisk = l.options.diskName(index + 1)
+	}
+	for j := range volume.files {
+		file := &volume.files[j]
+		span := l.spans[file.writerFile]
+		switch {
+		case span.first < index && span.last > index:
+			file.folderIndex = folderIndexContinuedPreviousAndNext
+		case span.first < index:
+			file.folderIndex = folderIndexContinuedFromPrevious
+		case span.last > index:
+			file.folderIndex = folderIndexContinuedToNext
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package cab
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+type mapFileWriter struct {
+	bytes.Buffer
+	fsys fstest.MapFS
+	name string
+}
+
+func (w *mapFileWriter) Close() error {
+	w.fsys[w.name] = &fstest.MapFile{Data: w.Bytes()}
+	return nil
+}
+
+// writeTestSet writes a multi-cabinet set and returns the cabinets in order.
+func writeTestSet(t *testing.T, files []writerTestFile, maxSize int64, compressions []Compression) (fstest.MapFS, []string) {
+	t.Helper()
+	fsys := fstest.MapFS{}
+	var names []string
+	writer, err := NewSetWriter(SetOptions{
+		MaxSize:      maxSize,
+		NameTemplate: "set/disk*.cab",
+		DiskTemplate: "Disk *",
+		Create: func(name string) (io.WriteCloser, error) {
+			names = append(names, name)
+			return &mapFileWriter{fsys: fsys, name: name}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.SetID(0x1234)
+	for i, file := range files {
+		if i < len(compressions) {
+			if err := writer.NewFolder(compressions[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fileWriter, err := writer.Create(file.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileWriter.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Each file is listed in the cabinet that FileCabinets returns
+	for i, index := range writer.FileCabinets() {
+		data := fsys[names[index]].Data
+		cabFile, err := Open(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, file := range cabFile.Files {
+			found = found || file.Name == files[i].header.Name
+		}
+		if !found {
+			t.Fatal(files[i].header.Name, "is not listed in", names[index])
+		}
+	}
+	return fsys, names
+}
+
+func TestSetWriter(t *testing.T) {
+	files := writerTestFiles()
+	for _, compressions := range [][]Compression{
+		{CompressionNone},
+		{CompressionMSZIP, CompressionNone, CompressionLZX(16)},
+	} {
+		for _, maxSize := range []int64{1000, 20000, 1 << 20} {
+			fsys, names := writeTestSet(t, files, maxSize, compressions)
+			if maxSize < 1<<20 && len(names) < 2 {
+				t.Fatal("expected several cabinets, got", names)
+			}
+			var readers []io.ReaderAt
+			var sizes []int64
+			for i, name := range names {
+				data := fsys[name].Data
+				if int64(len(data)) > maxSize {
+					t.Fatal(name, "is larger than the maximum size:", len(data))
+				}
+				cabFile, err := Open(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatal(name, err)
+				}
+				if cabFile.SetId != 0x1234 || cabFile.SetIndex != uint16(i) {
+					t.Fatal("unexpected set ID or index", cabFile.SetId, cabFile.SetIndex)
+				}
+				var expected MultiCabinetInfo
+				if i > 0 {
+					expected.PreviousFile, expected.PreviousDisk = names[i-1], fmt.Sprint("Disk ", i)
+				}
+				if i < len(names)-1 {
+					expected.NextFile, expected.NextDisk = names[i+1], fmt.Sprint("Disk ", i+2)
+				}
+				expected.SetId, expected.SetIndex = cabFile.SetId, cabFile.SetIndex
+				if cabFile.MultiCabinetInfo != expected {
+					t.Fatal("unexpected cabinet info", cabFile.MultiCabinetInfo)
+				}
+				readers = append(readers, bytes.NewReader(data))
+				sizes = append(sizes, int64(len(data)))
+			}
+
+			cabFile, err := OpenSetWithOptions(readers, sizes, &Options{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTestFiles(t, cabFile, files)
+			if len(cabFile.folders) != len(compressions) {
+				t.Fatal("expected", len(compressions), "folders, got", len(cabFile.folders))
+			}
+
+			// OpenFS follows the names in the cabinets
+			cabFile, err = OpenFS(fsys, names[len(names)/2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTestFiles(t, cabFile, files)
+			if err := cabFile.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSetWriterSplitBlocks(t *testing.T) {
+	files := writerTestFiles()
+	fsys, names := writeTestSet(t, files, 1000, []Compression{CompressionNone})
+	var splitBlocks int
+	for _, name := range names {
+		data := fsys[name].Data
+		cabFile, err := Open(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, folder := range cabFile.folders {
+			for _, entry := range folder.dataEntries {
+				if entry.UncompressedBytes == 0 {
+					splitBlocks++
+				}
+			}
+		}
+	}
+	if splitBlocks < len(names)-1 {
+		t.Fatal("expected blocks to be split at cabinet boundaries, got", splitBlocks)
+	}
+}
+
+func TestSetWriterWritesEarly(t *testing.T) {
+	fsys := fstest.MapFS{}
+	var written []string
+	writer, err := NewSetWriter(SetOptions{
+		MaxSize:      20000,
+		NameTemplate: "disk*.cab",
+		Create: func(name string) (io.WriteCloser, error) {
+			written = append(written, name)
+			return &mapFileWriter{fsys: fsys, name: name}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.NewFolder(CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	files := writerTestFiles()
+	for i, file := range files {
+		fileWriter, err := writer.Create(file.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileWriter.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			// 70100 bytes of data fill three cabinets, which are written while c\file.txt is current
+			if len(written) != 0 {
+				t.Fatal("cabinets listing the current file were written", written)
+			}
+			if err := writer.SetReserve(Reserve{Header: AuthenticodeHeaderReserve}); err == nil {
+				t.Fatal("expected error for reserve after the first file")
+			}
+		}
+	}
+	// c\file.txt is complete, so all cabinets up to the current one were written before Close
+	if len(written) < 3 || len(fsys) != len(written) {
+		t.Fatal("expected cabinets to be written before Close, got", written)
+	}
+	// The data of written cabinets is released
+	if writer.folders[0].blocks[0].data != nil {
+		t.Fatal("data of a written cabinet is still referenced")
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var readers []io.ReaderAt
+	var sizes []int64
+	for _, name := range written {
+		readers = append(readers, bytes.NewReader(fsys[name].Data))
+		sizes = append(sizes, int64(len(fsys[name].Data)))
+	}
+	cabFile, err := OpenSetWithOptions(readers, sizes, &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, cabFile, files)
+}
+
+func TestSetWriterErrors(t *testing.T) {
+	create := func(name string) (io.WriteCloser, error) {
+		return &mapFileWriter{fsys: fstest.MapFS{}, name: name}, nil
+	}
+	for _, options := range []SetOptions{
+		{MaxSize: 0, NameTemplate: "disk*.cab", Create: create},
+		{MaxSize: 1000, NameTemplate: "", Create: create},
+		{MaxSize: 1000, NameTemplate: "disk*.cab"},
+	} {
+		if _, err := NewSetWriter(options); err == nil {
+			t.Error("expected error for", options)
+		}
+	}
+
+	// A fixed name is fine for a single cabinet, but not for a set
+	for _, size := range []int{100, 10000} {
+		writer, err := NewSetWriter(SetOptions{MaxSize: 1000, NameTemplate: "disk.cab", Create: create})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.NewFolder(CompressionNone); err != nil {
+			t.Fatal(err)
+		}
+		fileWriter, err := writer.Create(FileHeader{Name: "file.txt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileWriter.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); (err == nil) != (size <= 1000) {
+			t.Fatal("unexpected result for", size, "bytes:", err)
+		}
+	}
+
+	// Too small for a header and a file entry
+	writer, err := NewSetWriter(SetOptions{MaxSize: 60, NameTemplate: "disk*.cab", Create: create})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter, err := writer.Create(FileHeader{Name: "file.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileWriter.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err == nil {
+		t.Fatal("expected error for small maximum size")
+	}
+}
@@ -0,0 +1,526 @@
+package cab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// StreamReader reads a cabinet sequentially from an io.Reader, similar to archive/tar.Reader. Unlike Open, it does
+// not need random access to the cabinet.
+//
+// The cabinet header and the CFFOLDER and CFFILE entries are read on the first call to Next. Files are returned
+// ordered by the position of their data in the cabinet, so that each folder is decompressed only once while reading
+// through the input; this may differ from the order of the CFFILE entries.
+//
+// Some layouts cannot be read sequentially without keeping data in memory: CFFILE entries that follow CFDATA
+// blocks, and files whose data overlaps with the next file in the same folder. In these cases, only the data that
+// is needed later is buffered, and OnBuffer is called.
+type StreamReader struct {
+	// OnBuffer is called whenever data must be buffered in memory because of the layout of the cabinet.
+	// It may be nil.
+	OnBuffer func(BufferEvent)
+
+	// ReservedHeaderBlock, Reserve and MultiCabinetInfo are set by the first call to Next.
+	ReservedHeaderBlock []byte
+	Reserve             Reserve
+	MultiCabinetInfo
+
+	options       Options
+	source        *streamSource
+	reservedSizes cabinetFileReservedSizes
+	// Uncompressed size of all data blocks read so far
+	uncompressedTotal int64
+	started           bool
+	err               error
+
+	files       []*File // Files that have not been returned yet
+	retainUntil []int64 // For each of files, the end of the data that the following files in its folder need
+	folder      *streamFolder
+
+	file      *File // File returned by the last call to Next
+	offset    int64 // Offset of the next byte of file in the folder
+	remaining int64 // Number of bytes of file that have not been read
+}
+
+// BufferReason describes why a StreamReader buffers data.
+type BufferReason int
+
+const (
+	// BufferFileEntries means that CFDATA blocks precede the CFFILE entries. The data between the CFFOLDER and
+	// CFFILE entries is buffered until it is decompressed.
+	BufferFileEntries BufferReason = iota + 1
+	// BufferOverlappingFiles means that the data of a file overlaps with the data of the next file in its folder.
+	// The overlapping part is buffered after it was decompressed.
+	BufferOverlappingFiles
+)
+
+func (r BufferReason) String() string {
+	switch r {
+	case BufferFileEntries:
+		return "CFFILE entries follow CFDATA blocks"
+	case BufferOverlappingFiles:
+		return "file data overlaps the next file"
+	default:
+		return "unknown reason"
+	}
+}
+
+// BufferEvent describes data that a StreamReader buffers in memory.
+type BufferEvent struct {
+	Reason BufferReason
+	File   *File // File whose data is buffered for BufferOverlappingFiles; nil otherwise
+	Size   int64 // Number of bytes that are buffered
+}
+
+// NewStreamReader creates a StreamReader reading from reader with the default options.
+func NewStreamReader(reader io.Reader) *StreamReader {
+	return NewStreamReaderWithOptions(reader, nil)
+}
+
+// NewStreamReaderWithOptions creates a StreamReader reading from reader with the given options, which may be nil.
+func NewStreamReaderWithOptions(reader io.Reader, opts *Options) *StreamReader {
+	return &StreamReader{
+		options: opts.normalized(),
+		source:  &streamSource{reader: bufio.NewReader(reader)},
+	}
+}
+
+// Next advances to the next file in the cabinet. Any unread data of the previous file is skipped.
+// It returns io.EOF after the last file.
+//
+// The returned File can only be read through the StreamReader; its Open and OpenReaderAt methods fail.
+func (s *StreamReader) Next() (*File, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if !s.started {
+		s.started = true
+		if err := s.readDirectory(); err != nil {
+			s.err = err
+			return nil, err
+		}
+	}
+	if s.file != nil {
+		// Errors are stored in the folder and returned when reading its files
+		io.Copy(io.Discard, s)
+		s.file = nil
+	}
+	if len(s.files) == 0 {
+		s.closeFolder()
+		s.err = io.EOF
+		return nil, io.EOF
+	}
+	file, retainUntil := s.files[0], s.retainUntil[0]
+	s.files, s.retainUntil = s.files[1:], s.retainUntil[1:]
+	if s.folder == nil || s.folder.folder != file.folder {
+		s.closeFolder()
+		s.folder = s.openFolder(file.folder)
+	}
+
+	s.file = file
+	s.offset = int64(file.header.UncompressedOffsetInFolder)
+	s.remaining = int64(file.header.UncompressedFileSize)
+	end := s.offset + s.remaining
+	retainFrom := int64(math.MaxInt64)
+	if len(s.files) > 0 && s.files[0].folder == file.folder {
+		retainFrom = int64(s.files[0].header.UncompressedOffsetInFolder)
+		if end > retainUntil {
+			end = retainUntil
+		}
+		if retainFrom < end && s.OnBuffer != nil {
+			s.OnBuffer(BufferEvent{Reason: BufferOverlappingFiles, File: file, Size: end - retainFrom})
+		}
+	}
+	s.folder.retain(s.offset, retainFrom, retainUntil)
+	return file, nil
+}
+
+// Read reads from the current file. It returns io.EOF at the end of the file.
+func (s *StreamReader) Read(b []byte) (int, error) {
+	if s.file == nil || s.remaining == 0 {
+		return 0, io.EOF
+	}
+	if s.folder.err != nil {
+		return 0, s.folder.err
+	}
+	if int64(len(b)) > s.remaining {
+		b = b[:s.remaining]
+	}
+	n, err := s.folder.readAt(b, s.offset)
+	s.offset += int64(n)
+	s.remaining -= int64(n)
+	if err == io.EOF {
+		err = nil
+		if s.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		s.folder.err = err
+	}
+	return n, err
+}
+
+// ChecksumMismatches returns the data blocks with mismatching checksums that were read so far, see
+// Cabinet.ChecksumMismatches. A block's checksum is verified once the block was read completely.
+func (s *StreamReader) ChecksumMismatches() []ChecksumMismatch {
+	return s.options.report.list()
+}
+
+// readDirectory reads the cabinet header and the CFFOLDER and CFFILE entries.
+func (s *StreamReader) readDirectory() error {
+	var cab Cabinet
+	cfHeader, reservedSizes, err := cab.readHeader(s.source, &s.options)
+	if err != nil {
+		return err
+	}
+	s.ReservedHeaderBlock = cab.ReservedHeaderBlock
+	s.Reserve = cab.Reserve
+	s.MultiCabinetInfo = cab.MultiCabinetInfo
+	s.reservedSizes = reservedSizes
+
+	folders, err := readFolderEntries(context.Background(), s.source, cfHeader.FolderCount, reservedSizes.ReservedFolderSize)
+	if err != nil {
+		return err
+	}
+
+	if err := s.options.checkDirectory(cfHeader, folders, s.source.position); err != nil {
+		return err
+	}
+
+	firstFileOffset := int64(cfHeader.FirstFileEntryOffset)
+	if firstFileOffset < s.source.position {
+		return fileError(0, firstFileOffset, fmt.Errorf("%w: CFFILE entries overlap the CFFOLDER entries", ErrInvalidLayout))
+	}
+	dataBeforeFiles := false
+	for _, folder := range folders {
+		if folder.CfDataCount > 0 && int64(folder.CoffCabStart) < firstFileOffset {
+			dataBeforeFiles = true
+		}
+	}
+
+	var fileEntries []cabinetFileEntry
+	if dataBeforeFiles {
+		// Keep everything from here on, including the CFFILE entries, so that the data blocks can be read later
+		buffered := &bytes.Buffer{}
+		bufferStart := s.source.position
+		if _, err := io.CopyN(buffered, s.source, firstFileOffset-bufferStart); err != nil {
+			return fileError(0, firstFileOffset, err)
+		}
+		if s.OnBuffer != nil {
+			s.OnBuffer(BufferEvent{Reason: BufferFileEntries, Size: int64(buffered.Len())})
+		}
+		entrySource := &streamSource{reader: io.TeeReader(s.source, buffered), position: firstFileOffset}
+		fileEntries, err = readFileEntries(context.Background(), entrySource, cfHeader.FileCount, s.options.Limits.MaxNameLength)
+		if err != nil {
+			return err
+		}
+		s.source = &streamSource{reader: io.MultiReader(buffered, s.source), position: bufferStart}
+	} else {
+		if err := s.source.skipTo(firstFileOffset); err != nil {
+			return fileError(0, firstFileOffset, err)
+		}
+		fileEntries, err = readFileEntries(context.Background(), s.source, cfHeader.FileCount, s.options.Limits.MaxNameLength)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.options.checkFiles(fileEntries); err != nil {
+		return err
+	}
+
+	for i := range folders {
+		folders[i].streamed = true
+		folders[i].options = &s.options
+		cab.folders = append(cab.folders, &folders[i])
+	}
+	for _, fileEntry := range fileEntries {
+		folder, err := cab.resolveFolder(fileEntry)
+		if err != nil {
+			return err
+		}
+		file, err := s.options.newFile(fileEntry, folder)
+		if err != nil {
+			return err
+		}
+		s.files = append(s.files, file)
+	}
+	sort.SliceStable(s.files, func(i, j int) bool {
+		a, b := s.files[i], s.files[j]
+		if a.folder != b.folder {
+			return a.folder.CoffCabStart < b.folder.CoffCabStart
+		}
+		return a.header.UncompressedOffsetInFolder < b.header.UncompressedOffsetInFolder
+	})
+	s.retainUntil = retainedEnds(s.files)
+	return nil
+}
+
+// openFolder starts decompressing a folder. Errors are stored in the returned streamFolder.
+func (s *StreamReader) openFolder(folder *cabinetFileFolder) *streamFolder {
+	stream := &streamFolder{folder: folder, retainFrom: math.MaxInt64}
+	if folder.continuedFromPrevious {
+		stream.err = folderError(folder.index, -1, ErrMissingVolume)
+		return stream
+	}
+	if err := s.source.skipTo(int64(folder.CoffCabStart)); err != nil {
+		stream.err = folderError(folder.index, int64(folder.CoffCabStart), err)
+		return stream
+	}
+	blocks := &streamBlocks{
+		source:       s.source,
+		folderIndex:  folder.index,
+		count:        int(folder.CfDataCount),
+		reservedSize: s.reservedSizes.ReservedDatablockSize,
+		options:      &s.options,
+		total:        &s.uncompressedTotal,
+	}
+	dataReaders := make([]io.ReadCloser, blocks.count)
+	for i := range dataReaders {
+		dataReaders[i] = &streamBlockReader{blocks: blocks, index: i}
+	}
+	blockSize := func(block int) (int, error) {
+		entry, err := blocks.load(block)
+		if err != nil {
+			return 0, err
+		}
+		return entry.uncompressedSize(), nil
+	}
+	var declaredSize func() int64
+	if s.options.Limits.limitsData() {
+		declaredSize = func() int64 {
+			return blocks.uncompressed
+		}
+	}
+	stream.reader, stream.err = newDecompressor(context.Background(), folder, dataReaders, blockSize, declaredSize, nil)
+	return stream
+}
+
+func (s *StreamReader) closeFolder() {
+	if s.folder != nil && s.folder.reader != nil {
+		s.folder.reader.Close()
+	}
+	s.folder = nil
+}
+
+// streamSource tracks the position in the cabinet while reading it sequentially.
+type streamSource struct {
+	reader   io.Reader
+	position int64
+}
+
+func (s *streamSource) Read(b []byte) (int, error) {
+	n, err := s.reader.Read(b)
+	s.position += int64(n)
+	return n, err
+}
+
+func (s *streamSource) offset() int64 {
+	return s.position
+}
+
+// skipTo discards the input up to the given offset.
+func (s *streamSource) skipTo(offset int64) error {
+	if offset < s.position {
+		return fmt.Errorf("%w: cabinet structures are not in stream order", ErrInvalidLayout)
+	}
+	_, err := io.CopyN(io.Discard, s, offset-s.position)
+	return truncated(err)
+}
+
+func (s *streamSource) readString(maxLength int) (string, error) {
+	var stringBuffer strings.Builder
+	var buffer [1]byte
+	for {
+		if _, err := io.ReadFull(s, buffer[:]); err != nil {
+			return "", err
+		}
+		if buffer[0] == 0 {
+			return stringBuffer.String(), nil
+		}
+		if maxLength > 0 && stringBuffer.Len() == maxLength {
+			return "", ErrNameTooLong
+		}
+		stringBuffer.WriteByte(buffer[0])
+	}
+}
+
+// streamBlocks reads the CFDATA blocks of a folder from a stream when they are first needed.
+type streamBlocks struct {
+	source       *streamSource
+	folderIndex  int
+	count        int
+	reservedSize uint8
+	options      *Options
+	entries      []*cabinetFileData
+
+	// Sizes of the loaded data blocks, for limit checks
+	compressed, uncompressed int64
+	total                    *int64 // Uncompressed size of the data blocks of all folders
+}
+
+// load reads data blocks up to the given block into memory and returns it.
+func (b *streamBlocks) load(index int) (*cabinetFileData, error) {
+	if index >= b.count {
+		return nil, errors.New("data block index out of range")
+	}
+	for len(b.entries) <= index {
+		entry := cabinetFileData{folderIndex: b.folderIndex, blockIndex: len(b.entries), offset: b.source.position}
+		if err := binary.Read(b.source, binary.LittleEndian, &entry.cabinetFileDataHeader); err != nil {
+			return nil, entry.error(err)
+		}
+		if b.reservedSize != 0 {
+			entry.reservedData = make([]byte, b.reservedSize)
+			if _, err := io.ReadFull(b.source, entry.reservedData); err != nil {
+				return nil, entry.error(err)
+			}
+		}
+		data := make([]byte, entry.CompressedBytes)
+		if _, err := io.ReadFull(b.source, data); err != nil {
+			return nil, entry.error(err)
+		}
+		entry.compressedData = io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
+		b.entries = append(b.entries, &entry)
+
+		b.compressed += int64(entry.CompressedBytes)
+		b.uncompressed += int64(entry.UncompressedBytes)
+		*b.total += int64(entry.UncompressedBytes)
+		if err := b.options.checkCompressionRatio(b.compressed, b.uncompressed); err != nil {
+			return nil, entry.error(err)
+		}
+		if b.options.Limits.MaxUncompressedSize > 0 && *b.total > b.options.Limits.MaxUncompressedSize {
+			return nil, entry.error(ErrUncompressedSizeLimit)
+		}
+	}
+	return b.entries[index], nil
+}
+
+// streamBlockReader reads a data block once the decompressor reaches it.
+type streamBlockReader struct {
+	blocks *streamBlocks
+	index  int
+	reader io.ReadCloser
+}
+
+func (r *streamBlockReader) Read(b []byte) (int, error) {
+	if r.reader == nil {
+		entry, err := r.blocks.load(r.index)
+		if err != nil {
+			return 0, err
+		}
+		if r.reader, err = openFileData(entry, r.blocks.options); err != nil {
+			return 0, err
+		}
+	}
+	return r.reader.Read(b)
+}
+
+func (r *streamBlockReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+// streamFolder decompresses a folder sequentially and keeps the data that is needed by following files.
+type streamFolder struct {
+	folder   *cabinetFileFolder
+	reader   io.ReadCloser
+	err      error
+	position int64 // Offset of reader in the uncompressed folder data
+
+	retained      []byte // Contiguous decompressed data from retainedStart, if not empty
+	retainedStart int64
+	// Decompressed data from retainFrom up to retainUntil is retained, which is the data of the following files that
+	// the current file overlaps
+	retainFrom, retainUntil int64
+}
+
+// retainedEnds returns, for each of the files, which are sorted by folder and offset, the end of the data of the
+// following files in the same folder. This is where data that is decompressed for a file no longer needs to be
+// retained for the following files.
+func retainedEnds(files []*File) []int64 {
+	ends := make([]int64, len(files))
+	for i := len(files) - 2; i >= 0; i-- {
+		next := files[i+1]
+		if next.folder != files[i].folder {
+			continue
+		}
+		ends[i] = int64(next.header.UncompressedOffsetInFolder) + int64(next.header.UncompressedFileSize)
+		if ends[i+1] > ends[i] {
+			ends[i] = ends[i+1]
+		}
+	}
+	return ends
+}
+
+// retain discards retained data before keepFrom and sets the range of newly decompressed data that is retained.
+func (f *streamFolder) retain(keepFrom, retainFrom, retainUntil int64) {
+	f.retainFrom, f.retainUntil = retainFrom, retainUntil
+	if keepFrom > f.retainedStart {
+		discard := keepFrom - f.retainedStart
+		if discard > int64(len(f.retained)) {
+			discard = int64(len(f.retained))
+		}
+		f.retained = f.retained[discard:]
+		f.retainedStart += discard
+	}
+	if len(f.retained) == 0 {
+		f.retained = nil
+	}
+}
+
+// readAt reads from the given offset, which must not precede the retained data.
+func (f *streamFolder) readAt(b []byte, offset int64) (int, error) {
+	if len(f.retained) > 0 && offset < f.position {
+		if offset < f.retainedStart || offset >= f.retainedStart+int64(len(f.retained)) {
+			return 0, errors.New("file data was not retained")
+		}
+		return copy(b, f.retained[offset-f.retainedStart:]), nil
+	}
+	if offset < f.position {
+		return 0, errors.New("file data was not retained")
+	}
+	if offset > f.position {
+		if _, err := io.CopyN(io.Discard, folderStreamReader{f}, offset-f.position); err != nil {
+			return 0, err
+		}
+	}
+	return folderStreamReader{f}.Read(b)
+}
+
+// folderStreamReader reads from the decompressor of a streamFolder and retains data as needed.
+type folderStreamReader struct {
+	folder *streamFolder
+}
+
+func (r folderStreamReader) Read(b []byte) (int, error) {
+	f := r.folder
+	n, err := f.reader.Read(b)
+	offset := f.position
+	f.position += int64(n)
+	start, end := offset, f.position
+	if len(f.retained) == 0 && start < f.retainFrom {
+		start = f.retainFrom
+	}
+	if end > f.retainUntil {
+		end = f.retainUntil
+	}
+	// Retained data must stay contiguous
+	if start < end && (len(f.retained) == 0 || f.retainedStart+int64(len(f.retained)) == start) {
+		if len(f.retained) == 0 {
+			f.retainedStart = start
+		}
+		f.retained = append(f.retained, b[start-offset:end-offset]...)
+	}
+	return n, err
+}
@@ -0,0 +1,156 @@
+package cab
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// readStream reads all files from a cabinet with a StreamReader, without allowing it to seek.
+func readStream(t *testing.T, cabData []byte, onBuffer func(BufferEvent)) map[string][]byte {
+	t.Helper()
+	stream := NewStreamReader(struct{ io.Reader }{bytes.NewReader(cabData)})
+	stream.OnBuffer = onBuffer
+	contents := map[string][]byte{}
+	for {
+		file, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		contents[file.Name] = content
+	}
+	return contents
+}
+
+func TestStreamReader(t *testing.T) {
+	for _, name := range []string{"simple.cab", "drivers.cab", "lzx.cab", "quantum.cab"} {
+		cabData, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cabFile, err := Open(bytes.NewReader(cabData), int64(len(cabData)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents := readStream(t, cabData, func(event BufferEvent) {
+			t.Fatal(name, "unexpected buffering:", event.Reason)
+		})
+		if len(contents) != len(cabFile.Files) {
+			t.Fatal(name, "file count differs:", len(contents), len(cabFile.Files))
+		}
+		for _, file := range cabFile.Files {
+			reader, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents[file.Name], expected) {
+				t.Fatal(name, "content differs for", file.Name)
+			}
+		}
+	}
+}
+
+func TestStreamReaderBuffering(t *testing.T) {
+	content := testSetContent()
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{
+			{content[:2000], 2000},
+			{content[2000:], 1500},
+		},
+		files: []testFile{
+			{"late.bin", 2500, 1000, 0},
+			{"first.bin", 0, 2200, 0},
+			{"overlap.bin", 1800, 1000, 0},
+		},
+		filesAfterData: true,
+	})
+	var events []BufferEvent
+	contents := readStream(t, cabData, func(event BufferEvent) {
+		events = append(events, event)
+	})
+	if !bytes.Equal(contents["first.bin"], content[:2200]) ||
+		!bytes.Equal(contents["overlap.bin"], content[1800:2800]) ||
+		!bytes.Equal(contents["late.bin"], content[2500:]) {
+		t.Fatal("content differs")
+	}
+	if len(events) != 3 {
+		t.Fatal("unexpected buffer events", events)
+	}
+	if events[0].Reason != BufferFileEntries || events[0].Size != 3500+2*8 {
+		t.Fatal("unexpected buffer event", events[0])
+	}
+	if events[1].Reason != BufferOverlappingFiles || events[1].File.Name != "first.bin" || events[1].Size != 400 {
+		t.Fatal("unexpected buffer event", events[1])
+	}
+	if events[2].Reason != BufferOverlappingFiles || events[2].File.Name != "overlap.bin" || events[2].Size != 300 {
+		t.Fatal("unexpected buffer event", events[2])
+	}
+}
+
+func TestStreamReaderBufferingOverlap(t *testing.T) {
+	content := testSetContent()
+	cabData := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:2000], 2000}, {content[2000:], 1500}},
+		files: []testFile{
+			{"whole.bin", 0, 3500, 0},
+			{"small.bin", 10, 10, 0},
+			{"other.bin", 30, 20, 0},
+		},
+	})
+	stream := NewStreamReader(bytes.NewReader(cabData))
+	var events []BufferEvent
+	stream.OnBuffer = func(event BufferEvent) {
+		events = append(events, event)
+	}
+	for {
+		file, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		start := file.header.UncompressedOffsetInFolder
+		if !bytes.Equal(data, content[start:start+file.header.UncompressedFileSize]) {
+			t.Fatal("content differs for", file.Name)
+		}
+		// Only the data of the following files is kept, not the rest of whole.bin
+		if len(stream.folder.retained) > 40 {
+			t.Fatal("retained", len(stream.folder.retained), "bytes after", file.Name)
+		}
+	}
+	if len(events) != 1 || events[0].File.Name != "whole.bin" || events[0].Size != 40 {
+		t.Fatal("unexpected buffer events", events)
+	}
+}
+
+func TestStreamReaderFileOpen(t *testing.T) {
+	cabData, err := os.ReadFile("testdata/simple.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewStreamReader(bytes.NewReader(cabData))
+	file, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Open(); err == nil {
+		t.Fatal("expected error when opening a streamed file")
+	}
+}
@@ -0,0 +1,169 @@
+package cab
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// OpenFS opens the cabinet with the given name from fsys. If the cabinet is part of a multi-cabinet set, the other
+// cabinets of the set are located via their PreviousFile and NextFile names in the same directory, and the whole set
+// is returned as one Cabinet (see OpenSet). Names are matched case-insensitively if no exact match exists.
+//
+// The returned Cabinet keeps the cabinet files open; they are released by Cabinet.Close.
+func OpenFS(fsys fs.FS, name string) (*Cabinet, error) {
+	return OpenFSWithOptions(fsys, name, nil)
+}
+
+// OpenFSWithOptions opens a cabinet or multi-cabinet set like OpenFS, using the given options for every cabinet.
+func OpenFSWithOptions(fsys fs.FS, name string, opts *Options) (cab *Cabinet, err error) {
+	// Normalize once, so that all cabinets share the checksum report
+	options := opts.normalized()
+	var closers []io.Closer
+	names := map[*Cabinet]string{} // File names of the opened cabinets
+	defer func() {
+		if err != nil {
+			for _, closer := range closers {
+				closer.Close()
+			}
+		}
+	}()
+	openVolume := func(name string) (*Cabinet, error) {
+		file, err := openFSVolume(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, file)
+		cab, err := OpenWithOptions(file, file.size, &options)
+		if err != nil {
+			return nil, err
+		}
+		names[cab] = file.name
+		return cab, nil
+	}
+
+	dir := path.Dir(name)
+	current, err := openVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	cabinets := []*Cabinet{current}
+	for first := current; first.PreviousFile != ""; first = cabinets[0] {
+		if first.SetIndex == 0 {
+			return nil, fmt.Errorf("%w: first cabinet in set references a previous cabinet", ErrInvalidSet)
+		}
+		previous, err := openVolume(path.Join(dir, volumeFileName(first.PreviousFile)))
+		if err != nil {
+			return nil, err
+		}
+		if previous.SetIndex != first.SetIndex-1 {
+			return nil, fmt.Errorf("%w: cabinets in set are not consecutive", ErrInvalidSet)
+		}
+		cabinets = append([]*Cabinet{previous}, cabinets...)
+	}
+	for last := current; last.NextFile != ""; last = cabinets[len(cabinets)-1] {
+		next, err := openVolume(path.Join(dir, volumeFileName(last.NextFile)))
+		if err != nil {
+			return nil, err
+		}
+		if next.SetIndex != last.SetIndex+1 {
+			return nil, fmt.Errorf("%w: cabinets in set are not consecutive", ErrInvalidSet)
+		}
+		cabinets = append(cabinets, next)
+	}
+
+	cabinetNames := make([]string, len(cabinets))
+	for i, cabinet := range cabinets {
+		cabinetNames[i] = names[cabinet]
+	}
+	cab, err = mergeSet(cabinets, cabinetNames)
+	if err != nil {
+		return nil, err
+	}
+	cab.closers = closers
+	return cab, nil
+}
+
+// Close releases the files that were opened by OpenFS. For cabinets opened from an io.ReaderAt, Close does nothing.
+func (cab *Cabinet) Close() (err error) {
+	for _, closer := range cab.closers {
+		if closeErr := closer.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	cab.closers = nil
+	return
+}
+
+// volumeFileName returns the file name of a cabinet referenced in PreviousFile or NextFile.
+// Cabinets created on Windows may use backslashes as path separators.
+func volumeFileName(name string) string {
+	return path.Base(strings.ReplaceAll(name, `\`, "/"))
+}
+
+type fsVolume struct {
+	io.ReaderAt
+	name string // Name of the file in fsys
+	size int64
+	file fs.File // nil if the file was read into memory
+}
+
+func (v *fsVolume) Close() error {
+	if v.file == nil {
+		return nil
+	}
+	return v.file.Close()
+}
+
+// openFSVolume opens a file from fsys for random access. If there is no exact match for the name, a case-insensitive
+// match in the same directory is used. Files that do not implement io.ReaderAt are read into memory.
+func openFSVolume(fsys fs.FS, name string) (*fsVolume, error) {
+	file, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		var matchErr error
+		name, matchErr = matchFileNameFold(fsys, name)
+		if matchErr != nil {
+			return nil, err
+		}
+		file, err = fsys.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if readerAt, isReaderAt := file.(io.ReaderAt); isReaderAt {
+		return &fsVolume{readerAt, name, info.Size(), file}, nil
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return &fsVolume{bytes.NewReader(data), name, int64(len(data)), nil}, nil
+}
+
+// matchFileNameFold looks for a file in the directory of name whose name matches case-insensitively.
+func matchFileNameFold(fsys fs.FS, name string) (string, error) {
+	dir, base := path.Split(name)
+	dir = path.Clean(dir)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), base) {
+			return path.Join(dir, entry.Name()), nil
+		}
+	}
+	return "", fs.ErrNotExist
+}
@@ -0,0 +1,218 @@
+package cab
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Walk calls fn for every file in the cabinet with a reader for the file's contents. The reader is only valid until
+// fn returns. If fn returns an error, Walk stops and returns that error.
+//
+// Unlike opening each file with File.Open, Walk decompresses every folder only once: files are visited ordered by
+// folder and by their offset within the folder, which may differ from the order in Files. If files overlap within a
+// folder, the overlapping data is kept in memory until the following file was read.
+//
+// The checksums of data blocks that were only read partially are verified once the decompression of a folder ends,
+// so a mismatch in the last data block of a file may be returned after fn returned for that file.
+func (cab *Cabinet) Walk(fn func(*File, io.Reader) error) error {
+	return cab.WalkContext(context.Background(), fn)
+}
+
+// WalkContext is like Walk, but stops decompressing once ctx is done and returns the error of ctx.
+func (cab *Cabinet) WalkContext(ctx context.Context, fn func(*File, io.Reader) error) error {
+	for _, unit := range cab.walkUnits(false) {
+		if err := unit.walk(ctx, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractParallel calls sink for every file in the cabinet like Walk, but decompresses different folders on up to
+// workers goroutines at once. If workers is not positive, runtime.GOMAXPROCS(0) is used. Uncompressed folders are
+// additionally split at CFDATA boundaries, so that their parts are read in parallel as well.
+//
+// Within a folder (or a part of an uncompressed folder), files are visited in the same order as by Walk; otherwise,
+// sink is called concurrently and in no particular order. The reader is only valid until sink returns. Once sink
+// or decompression returns an error, no further files are started and ExtractParallel returns the first error
+// after all running calls of sink have returned.
+func (cab *Cabinet) ExtractParallel(workers int, sink func(*File, io.Reader) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	units := cab.walkUnits(true)
+	var (
+		mutex    sync.Mutex
+		next     int
+		firstErr error
+	)
+	take := func() (walkUnit, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstErr != nil || next == len(units) {
+			return walkUnit{}, false
+		}
+		next++
+		return units[next-1], true
+	}
+	// stopped reports whether another unit failed, so that a running unit does not start further files
+	stopped := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firstErr != nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(units); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				unit, ok := take()
+				if !ok {
+					return
+				}
+				err := unit.walk(context.Background(), func(file *File, reader io.Reader) error {
+					if stopped() {
+						return errExtractionStopped
+					}
+					return sink(file, reader)
+				})
+				if err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// errExtractionStopped stops a unit of ExtractParallel after another unit failed. It is never returned, since the
+// error of the failed unit is recorded first.
+var errExtractionStopped = errors.New("extraction stopped")
+
+// walkUnit is a part of a folder that is decompressed in a single pass: either a whole folder, or the data blocks
+// of an uncompressed folder starting at firstBlock.
+type walkUnit struct {
+	folder     *cabinetFileFolder
+	firstBlock int
+	start      int64   // Uncompressed offset of firstBlock in the folder
+	files      []*File // Files starting in the unit, sorted by their offset
+}
+
+// walkUnits groups the files of the cabinet into walkUnits, in the order of Walk. If splitUncompressed is set,
+// the files of uncompressed folders are grouped by the data block that they start in.
+func (cab *Cabinet) walkUnits(splitUncompressed bool) []walkUnit {
+	var units []walkUnit
+	var blockStarts []int64
+	for _, file := range cab.sortedFiles() {
+		unit := walkUnit{folder: file.folder, files: []*File{file}}
+		if splitUncompressed && file.folder.CompressionType&compressionTypeMask == compressionTypeNone {
+			if len(units) == 0 || units[len(units)-1].folder != file.folder {
+				blockStarts = file.folder.blockStarts()
+			}
+			offset := int64(file.header.UncompressedOffsetInFolder)
+			// Last block starting at or before the file; files behind the end of the folder use the last block
+			unit.firstBlock = sort.Search(len(blockStarts), func(i int) bool {
+				return blockStarts[i] > offset
+			}) - 1
+			if unit.firstBlock < 0 {
+				unit.firstBlock = 0
+			} else {
+				unit.start = blockStarts[unit.firstBlock]
+			}
+		}
+		if last := len(units) - 1; last >= 0 && units[last].folder == unit.folder && units[last].firstBlock == unit.firstBlock {
+			units[last].files = append(units[last].files, file)
+			continue
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// walk calls fn for every file of the unit.
+func (u walkUnit) walk(ctx context.Context, fn func(*File, io.Reader) error) error {
+	stream := &streamFolder{folder: u.folder, position: u.start, retainFrom: math.MaxInt64}
+	reader, err := u.folder.openAt(ctx, u.firstBlock, len(u.folder.dataEntries), nil)
+	if err != nil {
+		return err
+	}
+	stream.reader = reader
+	defer reader.Close()
+	retainUntil := retainedEnds(u.files)
+	for i, file := range u.files {
+		offset := int64(file.header.UncompressedOffsetInFolder)
+		// Files are sorted by offset, so the next file starts before all following ones
+		retainFrom := int64(math.MaxInt64)
+		if i+1 < len(u.files) {
+			retainFrom = int64(u.files[i+1].header.UncompressedOffsetInFolder)
+		}
+		stream.retain(offset, retainFrom, retainUntil[i])
+		if err := fn(file, &walkFileReader{folder: stream, offset: offset, remaining: int64(file.header.UncompressedFileSize)}); err != nil {
+			return err
+		}
+		if stream.err != nil {
+			return stream.err
+		}
+	}
+	return reader.Close()
+}
+
+// sortedFiles returns the files of the cabinet, sorted by folder and by offset within the folder.
+func (cab *Cabinet) sortedFiles() []*File {
+	folderIndex := map[*cabinetFileFolder]int{}
+	for i, folder := range cab.folders {
+		folderIndex[folder] = i
+	}
+	files := append([]*File(nil), cab.Files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].folder != files[j].folder {
+			return folderIndex[files[i].folder] < folderIndex[files[j].folder]
+		}
+		return files[i].header.UncompressedOffsetInFolder < files[j].header.UncompressedOffsetInFolder
+	})
+	return files
+}
+
+// walkFileReader reads a file from the streamFolder of a walkUnit.
+type walkFileReader struct {
+	folder    *streamFolder
+	offset    int64
+	remaining int64
+}
+
+func (r *walkFileReader) Read(b []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if r.folder.err != nil {
+		return 0, r.folder.err
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+	n, err := r.folder.readAt(b, r.offset)
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+	if err == io.EOF {
+		err = nil
+		if r.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		r.folder.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,223 @@
+package cab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHashes, err := os.ReadFile("testdata/driverhashes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(expectedHashes))
+	for scanner.Scan() {
+		expected[strings.TrimSpace(scanner.Text())] = true
+	}
+	var visited int
+	err = cabFile.Walk(func(file *File, reader io.Reader) error {
+		sha256Hash := sha256.New()
+		if _, err := io.Copy(sha256Hash, reader); err != nil {
+			return err
+		}
+		hashline := fmt.Sprintf("%X %s", sha256Hash.Sum(nil), file.Name)
+		if !expected[hashline] {
+			return fmt.Errorf("unexpected hash: %s", hashline)
+		}
+		visited++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited != len(cabFile.Files) {
+		t.Fatal("expected", len(cabFile.Files), "files, visited", visited)
+	}
+}
+
+func TestWalkOverlappingFiles(t *testing.T) {
+	content := testSetContent()
+	volume := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1500], 1500}, {content[1500:3000], 1500}},
+		files: []testFile{
+			{"late.bin", 2000, 1000, 0},
+			{"whole.bin", 0, 3000, 0},
+			{"overlap.bin", 1000, 1500, 0},
+			{"partial.bin", 100, 100, 0},
+		},
+	})
+	cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visited []string
+	err = cabFile.Walk(func(file *File, reader io.Reader) error {
+		visited = append(visited, file.Name)
+		if file.Name == "partial.bin" {
+			// Read nothing; Walk must skip the remaining data
+			return nil
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		start := file.header.UncompressedOffsetInFolder
+		if !bytes.Equal(data, content[start:start+file.header.UncompressedFileSize]) {
+			return fmt.Errorf("content mismatch for %s", file.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(visited, ",") != "whole.bin,partial.bin,overlap.bin,late.bin" {
+		t.Fatal("unexpected order", visited)
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/drivers.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var visited int
+	err = cabFile.WalkContext(ctx, func(file *File, reader io.Reader) error {
+		visited++
+		cancel()
+		_, err := io.Copy(io.Discard, reader)
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+	if visited != 1 {
+		t.Fatal("expected walk to stop after the first file, visited", visited)
+	}
+}
+
+func TestExtractParallel(t *testing.T) {
+	for _, name := range []string{"drivers.cab", "lzx.cab"} {
+		testfileData, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cabFile, err := Open(bytes.NewReader(testfileData), int64(len(testfileData)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[*File][32]byte{}
+		if err := cabFile.Walk(func(file *File, reader io.Reader) error {
+			data, err := io.ReadAll(reader)
+			expected[file] = sha256.Sum256(data)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var mutex sync.Mutex
+		visited := map[*File]bool{}
+		err = cabFile.ExtractParallel(4, func(file *File, reader io.Reader) error {
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if visited[file] {
+				return fmt.Errorf("%s visited twice", file.Name)
+			}
+			visited[file] = true
+			if sha256.Sum256(data) != expected[file] {
+				return fmt.Errorf("content mismatch for %s", file.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if len(visited) != len(cabFile.Files) {
+			t.Fatal(name, "expected", len(cabFile.Files), "files, visited", len(visited))
+		}
+	}
+}
+
+func TestExtractParallelUncompressed(t *testing.T) {
+	content := testSetContent()
+	volume := buildTestVolume(testVolume{
+		blocks: []testBlock{{content[:1000], 1000}, {content[1000:2000], 1000}, {content[2000:3000], 1000}},
+		files: []testFile{
+			{"late.bin", 2500, 500, 0},
+			{"whole.bin", 0, 3000, 0},
+			{"overlap.bin", 1000, 1500, 0},
+			{"second.bin", 1200, 100, 0},
+			{"inner.bin", 1100, 100, 0},
+		},
+	})
+	cabFile, err := Open(bytes.NewReader(volume), int64(len(volume)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var units []string
+	for _, unit := range cabFile.walkUnits(true) {
+		var names []string
+		for _, file := range unit.files {
+			names = append(names, file.Name)
+		}
+		units = append(units, fmt.Sprintf("%d:%s", unit.firstBlock, strings.Join(names, ",")))
+	}
+	if strings.Join(units, " ") != "0:whole.bin 1:overlap.bin,inner.bin,second.bin 2:late.bin" {
+		t.Fatal("unexpected units", units)
+	}
+
+	var visited atomic.Int32
+	err = cabFile.ExtractParallel(0, func(file *File, reader io.Reader) error {
+		visited.Add(1)
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		start := file.header.UncompressedOffsetInFolder
+		if !bytes.Equal(data, content[start:start+file.header.UncompressedFileSize]) {
+			return fmt.Errorf("content mismatch for %s", file.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited.Load() != 5 {
+		t.Fatal("unexpected number of files", visited.Load())
+	}
+
+	sinkErr := errors.New("sink failed")
+	err = cabFile.ExtractParallel(2, func(file *File, reader io.Reader) error {
+		return sinkErr
+	})
+	if err != sinkErr {
+		t.Fatal("expected error of sink, got", err)
+	}
+}
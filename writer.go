@@ -0,0 +1,583 @@
+package cab
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/secDre4mer/go-cab/lzxenc"
+	"github.com/secDre4mer/go-cab/mszip"
+)
+
+// Compression is the compression type of a folder, as stored in CFFOLDER.
+type Compression uint16
+
+const (
+	CompressionNone  Compression = compressionTypeNone
+	CompressionMSZIP Compression = compressionTypeMszip
+)
+
+// CompressionLZX returns the compression type for LZX with a window of 2^windowBits bytes. windowBits must be between
+// 15 and 21; larger windows compress better, but decompression needs more memory.
+func CompressionLZX(windowBits int) Compression {
+	return Compression(compressionTypeLzx | windowBits<<8)
+}
+
+const (
+	// maxBlockSize is the uncompressed size of a data block; only the last block of a folder may be smaller.
+	maxBlockSize = 0x8000
+	// maxFolderSize is the maximum uncompressed size of a folder that MakeCAB produces.
+	maxFolderSize = 0x7FFF8000
+	// maxNameLength is the maximum length of a file name, without the terminating zero byte.
+	maxNameLength = 255
+)
+
+// FileHeader describes a file that is added to a cabinet with Writer.Create.
+type FileHeader struct {
+	// Name is the name of the file. Names that are not ASCII must be valid UTF-8; AttributeNameUtf is set for them.
+	Name string
+	// Modified is the modification time. It is stored as MS-DOS date and time in the location of Modified, so it
+	// must be between 1980 and 2107. If it is the zero time, the date and time are stored as 0.
+	Modified   time.Time
+	Attributes Attributes
+}
+
+// Writer creates a cabinet, or a multi-cabinet set, see NewSetWriter. Files are added with Create and stored in
+// folders; every folder is compressed as a whole, so files that are often extracted together should share a folder.
+// The compressed data of a single cabinet is kept in memory until Close writes it; see NewWriter.
+type Writer struct {
+	writer  io.Writer
+	set     *SetOptions // Options of a multi-cabinet set, or nil
+	layout  *setLayout  // Layout of a multi-cabinet set, once the first data block was produced
+	setID   uint16
+	reserve Reserve
+
+	compression Compression // Compression of the next folder
+	newFolder   bool        // Start a new folder for the next file
+	folders     []*writerFolder
+	files       []*writerFile
+	current     *writerFile
+	closed      bool
+	err         error // Sticky error of the file data writer
+}
+
+type writerFolder struct {
+	compression Compression
+	encoder     blockEncoder
+	pending     []byte // Uncompressed data of an incomplete block, which was not passed to the encoder yet
+	size        int64  // Uncompressed size of the folder
+	blocked     int64  // Uncompressed size of the blocks
+	blocks      []writerBlock
+	files       []*writerFile
+	laidOut     int // Number of blocks that were passed to the layout of a set
+}
+
+type writerBlock struct {
+	data         []byte // Compressed data
+	uncompressed uint16
+}
+
+type writerFile struct {
+	cabinetFileEntryHeader
+	name    string
+	folder  *writerFolder
+	cabinet int // Index of the first cabinet of a set that lists the file
+}
+
+// blockEncoder compresses the data of a folder into data blocks. Every data block except the last one contains
+// maxBlockSize bytes of uncompressed data.
+type blockEncoder interface {
+	// Encode compresses the next data of the folder and returns the data blocks that are complete. It must not keep
+	// a reference to data.
+	Encode(data []byte) ([][]byte, error)
+	// Flush returns the remaining data blocks at the end of the folder.
+	Flush() ([][]byte, error)
+}
+
+// independentBlocks is a blockEncoder for compressions that produce one data block for each call to Encode.
+type independentBlocks func(block []byte) ([]byte, error)
+
+func (encode independentBlocks) Encode(data []byte) ([][]byte, error) {
+	block, err := encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{block}, nil
+}
+
+func (independentBlocks) Flush() ([][]byte, error) {
+	return nil, nil
+}
+
+func storeBlock(block []byte) ([]byte, error) {
+	return append([]byte(nil), block...), nil
+}
+
+// NewWriter returns a Writer that writes a cabinet to writer. Folders are compressed with MSZIP unless NewFolder
+// selects a different compression.
+//
+// Nothing is written before Close, since the CFFOLDER and CFFILE entries precede the data blocks, but their number is
+// only known once all files were added. Until then, the Writer holds the compressed data of every folder in memory,
+// plus at most one uncompressed 32 KB block; for incompressible data, that is about as much memory as the cabinet is
+// large, up to the 4 GB limit of a cabinet. To bound the memory use, create a multi-cabinet set with NewSetWriter and
+// SetOptions.MaxSize instead; a set writer writes every cabinet as soon as it is complete.
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{writer: writer, compression: CompressionMSZIP, newFolder: true}
+}
+
+// SetID sets the set ID of the cabinet, which identifies the cabinets of a multi-cabinet set. For a set, it must be
+// called before the first file is created, since cabinets are written while files are added.
+func (w *Writer) SetID(id uint16) {
+	w.setID = id
+}
+
+// SetReserve sets the sizes of the reserved areas, which are filled with zeros; by default, there are none. Use
+// AuthenticodeHeaderReserve for cabinets that will be signed, and PatchReservedHeader to fill the header area after
+// Close. In a multi-cabinet set, every cabinet has the reserved areas, and SetReserve must be called before the first
+// file is created.
+func (w *Writer) SetReserve(reserve Reserve) error {
+	if w.closed {
+		return errors.New("writer is closed")
+	}
+	if w.set != nil && len(w.files) > 0 {
+		return errors.New("reserved areas of a set must be set before the first file")
+	}
+	if err := reserve.validate(); err != nil {
+		return err
+	}
+	w.reserve = reserve
+	return nil
+}
+
+// NewFolder ends the current folder; the files that are created afterwards are stored in a new folder with the given
+// compression. Calling NewFolder before the first file selects the compression of the first folder.
+//
+// A folder that only contains empty files has no data blocks, and it is stored with CompressionNone regardless of
+// the selected compression, since LZX decompressors, including the one that this package uses, fail on an empty stream.
+func (w *Writer) NewFolder(compression Compression) error {
+	if w.closed {
+		return errors.New("writer is closed")
+	}
+	if _, err := newBlockEncoder(compression); err != nil {
+		return err
+	}
+	if err := w.finishFolder(); err != nil {
+		return err
+	}
+	w.compression = compression
+	w.newFolder = true
+	return nil
+}
+
+// Create adds a file to the cabinet and returns a writer for its contents. The file's contents must be written before
+// the next call to Create, NewFolder or Close.
+func (w *Writer) Create(header FileHeader) (io.Writer, error) {
+	if w.closed {
+		return nil, errors.New("writer is closed")
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	if len(w.files) == 0xFFFF {
+		return nil, errors.New("too many files in cabinet")
+	}
+	if len(header.Name) == 0 || len(header.Name) > maxNameLength {
+		return nil, fmt.Errorf("invalid file name length %d", len(header.Name))
+	}
+	attributes := header.Attributes &^ AttributeNameUtf
+	for i := 0; i < len(header.Name); i++ {
+		if header.Name[i] == 0 {
+			return nil, fmt.Errorf("file name %q contains a zero byte", header.Name)
+		}
+		if header.Name[i] >= 0x80 {
+			attributes |= AttributeNameUtf
+		}
+	}
+	if attributes&AttributeNameUtf != 0 && !utf8.ValidString(header.Name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, header.Name)
+	}
+	date, dosTime, err := dosTimestamp(header.Modified)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.newFolder {
+		if err := w.startFolder(); err != nil {
+			return nil, err
+		}
+	}
+	folder := w.folders[len(w.folders)-1]
+	w.current = &writerFile{
+		cabinetFileEntryHeader: cabinetFileEntryHeader{
+			UncompressedOffsetInFolder: uint32(folder.size),
+			Date:                       date,
+			Time:                       dosTime,
+			Attributes:                 attributes,
+		},
+		name:   header.Name,
+		folder: folder,
+	}
+	w.files = append(w.files, w.current)
+	folder.files = append(folder.files, w.current)
+	if w.layout != nil {
+		// The previous file is complete, so the cabinets that list it may be written
+		if err := w.layout.flush(w.current, false); err != nil {
+			w.err = err
+			return nil, err
+		}
+	}
+	return fileWriter{w, w.current}, nil
+}
+
+func (w *Writer) startFolder() error {
+	if len(w.folders) == folderIndexContinuedFromPrevious {
+		return errors.New("too many folders in cabinet")
+	}
+	encoder, err := newBlockEncoder(w.compression)
+	if err != nil {
+		return err
+	}
+	w.folders = append(w.folders, &writerFolder{compression: w.compression, encoder: encoder})
+	w.newFolder = false
+	return nil
+}
+
+func newBlockEncoder(compression Compression) (blockEncoder, error) {
+	switch compression & compressionTypeMask {
+	case CompressionNone:
+		if compression == CompressionNone {
+			return independentBlocks(storeBlock), nil
+		}
+	case CompressionMSZIP:
+		if compression == CompressionMSZIP {
+			return independentBlocks(mszip.NewEncoder(flate.DefaultCompression).Encode), nil
+		}
+	case compressionTypeLzx:
+		if compression&^(compressionTypeMask|0x1F00) == 0 {
+			if encoder, err := lzxenc.NewEncoder(int(compression >> 8)); err == nil {
+				return encoder, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %#x", ErrUnsupportedCompression, uint16(compression))
+}
+
+// fileWriter writes the contents of a file to its folder.
+type fileWriter struct {
+	writer *Writer
+	file   *writerFile
+}
+
+func (f fileWriter) Write(data []byte) (int, error) {
+	w := f.writer
+	if w.closed || w.current != f.file {
+		return 0, errors.New("write to a file that is no longer current")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	folder := f.file.folder
+	if int64(f.file.UncompressedFileSize)+int64(len(data)) > 0xFFFFFFFF {
+		w.err = fmt.Errorf("file %s is too large", f.file.name)
+		return 0, w.err
+	}
+	if folder.size+int64(len(data)) > maxFolderSize {
+		w.err = errors.New("folder is too large, use NewFolder to start a new folder")
+		return 0, w.err
+	}
+	f.file.UncompressedFileSize += uint32(len(data))
+	folder.size += int64(len(data))
+	if err := folder.write(data); err != nil {
+		w.err = err
+		return 0, err
+	}
+	if w.set != nil {
+		if err := w.layOut(folder, false); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// write passes complete blocks of uncompressed data to the encoder. Blocks are encoded straight from data where
+// possible; only the data of an incomplete block is copied to pending.
+func (f *writerFolder) write(data []byte) error {
+	if len(f.pending) > 0 {
+		size := maxBlockSize - len(f.pending)
+		if size > len(data) {
+			size = len(data)
+		}
+		f.pending = append(f.pending, data[:size]...)
+		data = data[size:]
+		if len(f.pending) < maxBlockSize {
+			return nil
+		}
+		if err := f.encode(f.pending); err != nil {
+			return err
+		}
+		f.pending = f.pending[:0]
+	}
+	for len(data) >= maxBlockSize {
+		if err := f.encode(data[:maxBlockSize]); err != nil {
+			return err
+		}
+		data = data[maxBlockSize:]
+	}
+	f.pending = append(f.pending, data...)
+	return nil
+}
+
+// encode passes uncompressed data to the encoder.
+func (f *writerFolder) encode(data []byte) error {
+	blocks, err := f.encoder.Encode(data)
+	if err != nil {
+		return err
+	}
+	return f.addBlocks(blocks)
+}
+
+// addBlocks adds compressed data blocks to the folder. Each block contains maxBlockSize bytes of the uncompressed
+// data, except for the last block of the folder.
+func (f *writerFolder) addBlocks(blocks [][]byte) error {
+	for _, compressed := range blocks {
+		if len(f.blocks) == 0xFFFF {
+			return errors.New("too many data blocks in folder")
+		}
+		if len(compressed) > 0xFFFF {
+			return errors.New("compressed data block is too large")
+		}
+		uncompressed := f.size - f.blocked
+		if uncompressed > maxBlockSize {
+			uncompressed = maxBlockSize
+		}
+		f.blocks = append(f.blocks, writerBlock{data: compressed, uncompressed: uint16(uncompressed)})
+		f.blocked += uncompressed
+	}
+	return nil
+}
+
+// finishFolder compresses the remaining data of the current folder.
+func (w *Writer) finishFolder() error {
+	w.current = nil
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.folders) == 0 || w.newFolder {
+		// No folder, or the last folder was already finished
+		return nil
+	}
+	folder := w.folders[len(w.folders)-1]
+	if len(folder.pending) > 0 {
+		if err := folder.encode(folder.pending); err != nil {
+			w.err = err
+			return err
+		}
+		folder.pending = nil
+	}
+	blocks, err := folder.encoder.Flush()
+	if err == nil {
+		err = folder.addBlocks(blocks)
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if folder.size == 0 {
+		// There is nothing to decompress, see NewFolder
+		folder.compression = CompressionNone
+	}
+	if w.set != nil {
+		if err := w.layOut(folder, true); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the cabinet, or the remaining cabinets of a set. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errors.New("writer is closed")
+	}
+	if err := w.finishFolder(); err != nil {
+		return err
+	}
+	w.closed = true
+	if w.set != nil {
+		return w.layOut(nil, true)
+	}
+
+	volume := &writerVolume{MultiCabinetInfo: MultiCabinetInfo{SetId: w.setID}, reserve: w.reserve}
+	for i, folder := range w.folders {
+		volume.folders = append(volume.folders, &volumeFolder{compression: folder.compression, blocks: folder.blocks})
+		for _, file := range w.files {
+			if file.folder == folder {
+				volume.files = append(volume.files, volumeFile{writerFile: file, folderIndex: uint16(i)})
+			}
+		}
+	}
+	return volume.write(w.writer)
+}
+
+// FileCabinets returns, for each file in the order of Create, the index of the first cabinet of the set that lists
+// the file. This is where extraction of the file starts. It is only valid after Close; for a single cabinet, all
+// indices are 0.
+func (w *Writer) FileCabinets() []int {
+	indices := make([]int, len(w.files))
+	for i, file := range w.files {
+		indices[i] = file.cabinet
+	}
+	return indices
+}
+
+// writerVolume is the layout of a single cabinet, which may be part of a multi-cabinet set.
+type writerVolume struct {
+	MultiCabinetInfo // The previous and next cabinet are only stored if their file names are set
+	reserve          Reserve
+	folders          []*volumeFolder
+	files            []volumeFile
+}
+
+// volumeFolder is the part of a folder that is stored in a cabinet.
+type volumeFolder struct {
+	compression Compression
+	blocks      []writerBlock
+}
+
+type volumeFile struct {
+	*writerFile
+	folderIndex uint16
+}
+
+// write writes the cabinet.
+func (v *writerVolume) write(writer io.Writer) error {
+	header := cabinetFileHeader{
+		Signature:    [4]byte{0x4D, 0x53, 0x43, 0x46},
+		VersionMinor: 3,
+		VersionMajor: 1,
+		FolderCount:  uint16(len(v.folders)),
+		FileCount:    uint16(len(v.files)),
+		SetId:        v.SetId,
+		SetIndex:     v.SetIndex,
+	}
+	folderOffset := int64(binary.Size(header)) + v.reserve.headerSize()
+	if v.reserve != (Reserve{}) {
+		header.Flags |= cabinetReserveExists
+	}
+	if v.PreviousFile != "" {
+		header.Flags |= previousCabinetExists
+		folderOffset += int64(len(v.PreviousFile) + len(v.PreviousDisk) + 2)
+	}
+	if v.NextFile != "" {
+		header.Flags |= nextCabinetExists
+		folderOffset += int64(len(v.NextFile) + len(v.NextDisk) + 2)
+	}
+	folderEntrySize := int64(binary.Size(cabinetFileFolderHeader{}) + v.reserve.Folder)
+	dataHeaderSize := int64(binary.Size(cabinetFileDataHeader{}) + v.reserve.Data)
+	fileOffset := folderOffset + int64(len(v.folders))*folderEntrySize
+	dataOffset := fileOffset
+	for _, file := range v.files {
+		dataOffset += int64(binary.Size(cabinetFileEntryHeader{}) + len(file.name) + 1)
+	}
+	size := dataOffset
+	for _, folder := range v.folders {
+		for _, block := range folder.blocks {
+			size += dataHeaderSize + int64(len(block.data))
+		}
+	}
+	if size > 0xFFFFFFFF {
+		return errors.New("cabinet is too large")
+	}
+	if len(v.files) > 0xFFFF {
+		// Files that span cabinets are listed in several cabinets of a set
+		return errors.New("too many files in cabinet")
+	}
+	header.Filesize = uint32(size)
+	header.FirstFileEntryOffset = uint32(fileOffset)
+
+	output := bufio.NewWriter(writer)
+	binary.Write(output, binary.LittleEndian, header)
+	if v.reserve != (Reserve{}) {
+		binary.Write(output, binary.LittleEndian, cabinetFileReservedSizes{
+			ReservedHeaderSize:    uint16(v.reserve.Header),
+			ReservedFolderSize:    uint8(v.reserve.Folder),
+			ReservedDatablockSize: uint8(v.reserve.Data),
+		})
+		output.Write(make([]byte, v.reserve.Header))
+	}
+	if v.PreviousFile != "" {
+		writeStrings(output, v.PreviousFile, v.PreviousDisk)
+	}
+	if v.NextFile != "" {
+		writeStrings(output, v.NextFile, v.NextDisk)
+	}
+	blockOffset := dataOffset
+	for _, folder := range v.folders {
+		binary.Write(output, binary.LittleEndian, cabinetFileFolderHeader{
+			CoffCabStart:    uint32(blockOffset),
+			CfDataCount:     uint16(len(folder.blocks)),
+			CompressionType: uint16(folder.compression),
+		})
+		output.Write(make([]byte, v.reserve.Folder))
+		for _, block := range folder.blocks {
+			blockOffset += dataHeaderSize + int64(len(block.data))
+		}
+	}
+	for _, file := range v.files {
+		entry := file.cabinetFileEntryHeader
+		entry.FolderIndex = file.folderIndex
+		binary.Write(output, binary.LittleEndian, entry)
+		writeStrings(output, file.name)
+	}
+	reserved := make([]byte, v.reserve.Data)
+	for _, folder := range v.folders {
+		for _, block := range folder.blocks {
+			binary.Write(output, binary.LittleEndian, cabinetFileDataHeader{
+				Checksum:          dataChecksum(block.data, uint16(len(block.data)), block.uncompressed, reserved),
+				CompressedBytes:   uint16(len(block.data)),
+				UncompressedBytes: block.uncompressed,
+			})
+			output.Write(reserved)
+			output.Write(block.data)
+		}
+	}
+	// bufio.Writer keeps the first error, so it is enough to check it when flushing
+	return output.Flush()
+}
+
+// writeStrings writes zero-terminated strings.
+func writeStrings(output *bufio.Writer, values ...string) {
+	for _, value := range values {
+		output.WriteString(value)
+		output.WriteByte(0)
+	}
+}
+
+// dataChecksum computes the checksum of a CFDATA block, which covers the compressed data, the remaining fields of
+// the CFDATA header and the reserved area.
+func dataChecksum(data []byte, compressed, uncompressed uint16, reserved []byte) uint32 {
+	header := make([]byte, 4, 4+len(reserved))
+	binary.LittleEndian.PutUint16(header[0:], compressed)
+	binary.LittleEndian.PutUint16(header[2:], uncompressed)
+	return computeChecksum(append(header, reserved...), computeChecksum(data, 0))
+}
+
+// dosTimestamp converts a time to an MS-DOS date and time, in the location of the time.
+func dosTimestamp(t time.Time) (uint16, uint16, error) {
+	if t.IsZero() {
+		return 0, 0, nil
+	}
+	if t.Year() < 1980 || t.Year() > 2107 {
+		return 0, 0, fmt.Errorf("timestamp %v cannot be stored in a cabinet", t)
+	}
+	date := (t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day()
+	dosTime := t.Hour()<<11 | t.Minute()<<5 | t.Second()/2
+	return uint16(date), uint16(dosTime), nil
+}
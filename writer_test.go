@@ -0,0 +1,469 @@
+package cab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type writerTestFile struct {
+	header FileHeader
+	data   []byte
+}
+
+// writeTestCabinet writes a cabinet to a temporary file. newFolder is called before each file and may start a new
+// folder.
+func writeTestCabinet(t *testing.T, files []writerTestFile, newFolder func(w *Writer, index int) error) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.cab")
+	output, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	writer := NewWriter(output)
+	for i, file := range files {
+		if newFolder != nil {
+			if err := newFolder(writer, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fileWriter, err := writer.Create(file.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileWriter.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkTestCabinet opens a written cabinet and compares its files.
+func checkTestCabinet(t *testing.T, path string, files []writerTestFile) *Cabinet {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(data), int64(len(data)), &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFiles(t, cabFile, files)
+	return cabFile
+}
+
+// checkTestFiles compares the files of a cabinet with the written files.
+func checkTestFiles(t *testing.T, cabFile *Cabinet, files []writerTestFile) {
+	t.Helper()
+	if len(cabFile.Files) != len(files) {
+		t.Fatal("expected", len(files), "files, got", len(cabFile.Files))
+	}
+	for i, file := range cabFile.Files {
+		expected := files[i]
+		if file.Name != expected.header.Name || !file.Modified.Equal(expected.header.Modified) {
+			t.Fatal("unexpected name or timestamp", file.Name, file.Modified)
+		}
+		if file.Attributes&^AttributeNameUtf != expected.header.Attributes {
+			t.Fatal("unexpected attributes", file.Attributes)
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		if !bytes.Equal(content, expected.data) {
+			t.Fatal("content mismatch for", file.Name)
+		}
+	}
+}
+
+func writerTestFiles() []writerTestFile {
+	random := rand.New(rand.NewSource(1))
+	modified := time.Date(2023, 5, 17, 13, 45, 10, 0, time.Local)
+	var files []writerTestFile
+	for i, size := range []int{100, 0, 70000, 32768, 5, 100000} {
+		data := make([]byte, size)
+		// Compressible, but not trivial data
+		for j := range data {
+			data[j] = byte('a' + random.Intn(4))
+		}
+		files = append(files, writerTestFile{
+			header: FileHeader{Name: string(rune('a'+i)) + `\file.txt`, Modified: modified, Attributes: AttributeArch},
+			data:   data,
+		})
+	}
+	files[1].header.Name = "Ünïcode.txt"
+	files[2].header.Attributes |= AttributeReadOnly | AttributeExec
+	return files
+}
+
+func TestWriter(t *testing.T) {
+	files := writerTestFiles()
+	for _, compression := range []Compression{CompressionNone, CompressionMSZIP, CompressionLZX(15), CompressionLZX(21)} {
+		path := writeTestCabinet(t, files, func(w *Writer, index int) error {
+			if index == 0 {
+				return w.NewFolder(compression)
+			}
+			return nil
+		})
+		cabFile := checkTestCabinet(t, path, files)
+		if len(cabFile.folders) != 1 || cabFile.folders[0].CompressionType != uint16(compression) {
+			t.Fatal("unexpected folders", len(cabFile.folders))
+		}
+		if cabFile.Files[1].Attributes&AttributeNameUtf == 0 {
+			t.Fatal("UTF-8 attribute not set")
+		}
+		// All blocks but the last have the full size
+		for _, entry := range cabFile.folders[0].dataEntries[:len(cabFile.folders[0].dataEntries)-1] {
+			if entry.UncompressedBytes != maxBlockSize {
+				t.Fatal("unexpected block size", entry.UncompressedBytes)
+			}
+		}
+	}
+}
+
+func TestWriterFolders(t *testing.T) {
+	files := writerTestFiles()
+	compressions := []Compression{CompressionMSZIP, CompressionNone, CompressionMSZIP}
+	path := writeTestCabinet(t, files, func(w *Writer, index int) error {
+		if index%2 == 0 {
+			return w.NewFolder(compressions[index/2])
+		}
+		return nil
+	})
+	cabFile := checkTestCabinet(t, path, files)
+	if len(cabFile.folders) != 3 {
+		t.Fatal("expected 3 folders, got", len(cabFile.folders))
+	}
+	for i, folder := range cabFile.folders {
+		if folder.CompressionType != uint16(compressions[i]) {
+			t.Fatal("unexpected compression type", folder.CompressionType)
+		}
+	}
+	if cabFile.Files[3].header.FolderIndex != 1 || cabFile.Files[3].header.UncompressedOffsetInFolder != 70000 {
+		t.Fatal("unexpected folder or offset", cabFile.Files[3].header)
+	}
+}
+
+func TestWriterWriteSizes(t *testing.T) {
+	data := bytes.Repeat(writerTestFiles()[5].data, 3)
+	for _, compression := range []Compression{CompressionNone, CompressionMSZIP, CompressionLZX(15)} {
+		var expected []byte
+		for _, chunkSize := range []int{len(data), 1, 1000, 50000, maxBlockSize} {
+			var output bytes.Buffer
+			writer := NewWriter(&output)
+			if err := writer.NewFolder(compression); err != nil {
+				t.Fatal(err)
+			}
+			fileWriter, err := writer.Create(FileHeader{Name: "file.bin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for offset := 0; offset < len(data); offset += chunkSize {
+				end := offset + chunkSize
+				if end > len(data) {
+					end = len(data)
+				}
+				chunk := append([]byte(nil), data[offset:end]...)
+				if _, err := fileWriter.Write(chunk); err != nil {
+					t.Fatal(err)
+				}
+				// The writer must not keep a reference to the written data
+				for i := range chunk {
+					chunk[i] = 0
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if expected == nil {
+				expected = output.Bytes()
+			} else if !bytes.Equal(output.Bytes(), expected) {
+				t.Fatal("output depends on the size of writes", compression, chunkSize)
+			}
+		}
+		cabFile, err := Open(bytes.NewReader(expected), int64(len(expected)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTestFiles(t, cabFile, []writerTestFile{{header: FileHeader{Name: "file.bin"}, data: data}})
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	writer := NewWriter(nil)
+	for _, header := range []FileHeader{
+		{Name: ""},
+		{Name: "zero\x00byte"},
+		{Name: "invalid\xff"},
+		{Name: "old", Modified: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if _, err := writer.Create(header); err == nil {
+			t.Error("expected error for", header)
+		}
+	}
+	for _, compression := range []Compression{0xF, CompressionMSZIP | 0x100, CompressionLZX(14), CompressionLZX(22)} {
+		if err := writer.NewFolder(compression); err == nil {
+			t.Error("expected error for unsupported compression", compression)
+		}
+	}
+}
+
+func TestWriterLZX(t *testing.T) {
+	files := writerTestFiles()
+	// A folder without data, a folder that is finished twice and a folder that spans several LZX blocks
+	files = append(files[1:2], append(files, writerTestFile{
+		header: FileHeader{Name: "large.bin"},
+		data:   bytes.Repeat(files[5].data, 12),
+	})...)
+	path := writeTestCabinet(t, files, func(w *Writer, index int) error {
+		switch index {
+		case 0:
+			return w.NewFolder(CompressionLZX(16))
+		case 1:
+			if err := w.NewFolder(CompressionLZX(17)); err != nil {
+				return err
+			}
+			return w.NewFolder(CompressionLZX(18))
+		}
+		return nil
+	})
+	cabFile := checkTestCabinet(t, path, files)
+	if len(cabFile.folders) != 2 || cabFile.folders[0].CompressionType != uint16(CompressionNone) || cabFile.folders[1].CompressionType != uint16(CompressionLZX(18)) {
+		t.Fatal("unexpected folders", len(cabFile.folders))
+	}
+	var compressed, uncompressed int
+	for _, entry := range cabFile.folders[1].dataEntries {
+		compressed += int(entry.CompressedBytes)
+		uncompressed += int(entry.UncompressedBytes)
+	}
+	if compressed > uncompressed/3 {
+		t.Fatal("poor compression ratio", compressed, uncompressed)
+	}
+}
+
+func TestWriterEmptyFolder(t *testing.T) {
+	files := []writerTestFile{{header: FileHeader{Name: "empty.txt"}}, {header: FileHeader{Name: "data.txt"}, data: []byte("data")}}
+	for _, compression := range []Compression{CompressionMSZIP, CompressionLZX(21)} {
+		path := writeTestCabinet(t, files, func(w *Writer, index int) error {
+			return w.NewFolder(compression)
+		})
+		cabFile := checkTestCabinet(t, path, files)
+		if len(cabFile.folders) != 2 {
+			t.Fatal("unexpected folders", len(cabFile.folders))
+		}
+		// The empty folder is stored without compression, the other one keeps the selected compression
+		empty, nonEmpty := cabFile.folders[0], cabFile.folders[1]
+		if empty.CompressionType != uint16(CompressionNone) || len(empty.dataEntries) != 0 {
+			t.Fatal("unexpected empty folder", empty.CompressionType, len(empty.dataEntries))
+		}
+		if nonEmpty.CompressionType != uint16(compression) {
+			t.Fatal("unexpected compression type", nonEmpty.CompressionType)
+		}
+	}
+}
+
+// TestWrittenCabinet checks a cabinet that was written like in TestWriterCabextract. The hashes in
+// writtenhashes.txt were computed from the files that bsdtar (libarchive) extracted from it.
+func TestWrittenCabinet(t *testing.T) {
+	testfileData, err := os.ReadFile("testdata/written.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(testfileData), int64(len(testfileData)), &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHashes, err := os.ReadFile("testdata/writtenhashes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Split(strings.TrimSpace(string(expectedHashes)), "\n")
+	files := writerTestFiles()
+	if len(cabFile.Files) != len(expected) || len(files) != len(expected) {
+		t.Fatal("unexpected number of files", len(cabFile.Files))
+	}
+	for i, file := range cabFile.Files {
+		// The extracted files must match the data that was written
+		if hashline := fmt.Sprintf("%X %s", sha256.Sum256(files[i].data), files[i].header.Name); hashline != expected[i] {
+			t.Fatal("written data differs from extracted data:", hashline)
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashline := fmt.Sprintf("%X %s", sha256.Sum256(data), file.Name); hashline != expected[i] {
+			t.Fatal("unexpected hash:", hashline)
+		}
+	}
+	for i, compression := range []Compression{CompressionMSZIP, CompressionNone, CompressionLZX(21)} {
+		if cabFile.folders[i].CompressionType != uint16(compression) {
+			t.Fatal("unexpected compression type", cabFile.folders[i].CompressionType)
+		}
+	}
+}
+
+// lzxWindowTestFiles returns files for a cabinet with an LZX folder for every window size. Each folder contains
+// more than one LZX block of text, and noise that repeats at a distance that only fits into windows of 1 MB or more.
+func lzxWindowTestFiles() []writerTestFile {
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 8000)
+	random.Read(noise)
+	var text bytes.Buffer
+	words := []string{"cabinet", "folder", "file", "data", "block", "LZX", "window", "frame", "\n"}
+	for text.Len() < 270000 {
+		text.WriteString(words[random.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+	distant := append(append(append([]byte(nil), noise...), make([]byte, 700000)...), noise...)
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var files []writerTestFile
+	for windowBits := 15; windowBits <= 21; windowBits++ {
+		files = append(files,
+			writerTestFile{header: FileHeader{Name: fmt.Sprintf(`w%d\text.txt`, windowBits), Modified: modified}, data: text.Bytes()},
+			writerTestFile{header: FileHeader{Name: fmt.Sprintf(`w%d\distant.bin`, windowBits), Modified: modified}, data: distant},
+		)
+	}
+	return files
+}
+
+// writeLZXWindowCabinet writes the files of lzxWindowTestFiles, starting a folder for each window size.
+func writeLZXWindowCabinet(t *testing.T) string {
+	return writeTestCabinet(t, lzxWindowTestFiles(), func(w *Writer, index int) error {
+		if index%2 == 0 {
+			return w.NewFolder(CompressionLZX(15 + index/2))
+		}
+		return nil
+	})
+}
+
+func TestWriterLZXCabextract(t *testing.T) {
+	if cabextract == "" {
+		t.Skip("cabextract is not installed")
+	}
+	files := lzxWindowTestFiles()
+	path := writeLZXWindowCabinet(t)
+	output, err := exec.Command(cabextract, "-t", path).CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(output))
+	}
+	// cabextract lists and filters files by their converted names, in the order of the cabinet
+	listing, err := runCabExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing) != len(files) {
+		t.Fatal("unexpected listing", listing)
+	}
+	for i, file := range listing {
+		data, err := getCabExtractFile(path, file.Name)
+		if err != nil {
+			t.Fatal(file.Name, err)
+		}
+		if !bytes.Equal(data, files[i].data) {
+			t.Fatal("cabextract extracted different data for", files[i].header.Name)
+		}
+	}
+}
+
+// TestLZXWindowsCabinet checks lzxwindows.cab, which was written by writeLZXWindowCabinet. The hashes in
+// lzxwindowshashes.txt were computed from the files that bsdtar (libarchive) extracted from it. Since the Writer
+// must still produce the same cabinet, this also checks the current LZX encoder against an independent decoder.
+func TestLZXWindowsCabinet(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/lzxwindows.cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(writeLZXWindowCabinet(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, fixture) {
+		t.Fatal("the Writer no longer produces lzxwindows.cab")
+	}
+	cabFile, err := OpenWithOptions(bytes.NewReader(fixture), int64(len(fixture)), &Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHashes, err := os.ReadFile("testdata/lzxwindowshashes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Split(strings.TrimSpace(string(expectedHashes)), "\n")
+	if len(cabFile.Files) != len(expected) || len(cabFile.folders) != 7 {
+		t.Fatal("unexpected number of files or folders", len(cabFile.Files), len(cabFile.folders))
+	}
+	files := lzxWindowTestFiles()
+	for i, file := range cabFile.Files {
+		// The extracted files must match the data that was written
+		if hashline := fmt.Sprintf("%X %s", sha256.Sum256(files[i].data), files[i].header.Name); hashline != expected[i] {
+			t.Fatal("written data differs from extracted data:", hashline)
+		}
+		if compression := CompressionLZX(15 + i/2); cabFile.folders[i/2].CompressionType != uint16(compression) {
+			t.Fatal("unexpected compression type", cabFile.folders[i/2].CompressionType)
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashline := fmt.Sprintf("%X %s", sha256.Sum256(data), file.Name); hashline != expected[i] {
+			t.Fatal("unexpected hash:", hashline)
+		}
+	}
+}
+
+func TestWriterCabextract(t *testing.T) {
+	if cabextract == "" {
+		t.Skip("cabextract is not installed")
+	}
+	files := writerTestFiles()
+	path := writeTestCabinet(t, files, func(w *Writer, index int) error {
+		switch index {
+		case 3:
+			return w.NewFolder(CompressionNone)
+		case 4:
+			return w.NewFolder(CompressionLZX(21))
+		}
+		return nil
+	})
+	output, err := exec.Command(cabextract, "-t", path).CombinedOutput()
+	if err != nil {
+		t.Fatal(err, string(output))
+	}
+	listing, err := runCabExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing) != len(files) {
+		t.Fatal("unexpected listing", listing)
+	}
+	for i, file := range listing {
+		if file.Filesize != len(files[i].data) || !file.Modified.Equal(files[i].header.Modified) {
+			t.Fatal("unexpected file", file)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package cab
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const xattrSupported = true
+
+// setDOSAttributes stores the DOS attributes in the user.DOSATTRIB extended attribute. Samba reads the hexadecimal
+// text format besides its binary format.
+func setDOSAttributes(path string, attributes Attributes) error {
+	value := fmt.Sprintf("0x%x", uint16(attributes&dosAttributeMask))
+	if err := syscall.Setxattr(path, "user.DOSATTRIB", []byte(value), 0); err != nil {
+		return fmt.Errorf("setting DOS attributes of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package cab
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestExtractToDOSAttributes(t *testing.T) {
+	cabFile, _ := buildExtractTestCabinet(t, "hidden.txt")
+	cabFile.Files[0].Attributes |= AttributeHidden | AttributeExec
+	dir := t.TempDir()
+	if _, err := cabFile.ExtractTo(dir, &ExtractOptions{DOSAttributes: true}); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skip("extended attributes are not supported:", err)
+		}
+		t.Fatal(err)
+	}
+	value := make([]byte, 64)
+	n, err := syscall.Getxattr(filepath.Join(dir, "hidden.txt"), "user.DOSATTRIB", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value[:n]) != "0x22" {
+		t.Fatalf("unexpected attribute value %q", value[:n])
+	}
+}
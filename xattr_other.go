@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cab
+
+const xattrSupported = false
+
+func setDOSAttributes(path string, attributes Attributes) error {
+	return nil
+}